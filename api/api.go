@@ -0,0 +1,50 @@
+package api
+
+import (
+	"os"
+	"time"
+
+	"github.com/dikletscode/isyana-store/pkg/media"
+	"github.com/dikletscode/isyana-store/pkg/payment"
+	"github.com/dikletscode/isyana-store/pkg/router"
+	"github.com/dikletscode/isyana-store/pkg/storage"
+	"github.com/dikletscode/isyana-store/services/auth"
+	"github.com/dikletscode/isyana-store/services/category"
+	"github.com/dikletscode/isyana-store/services/order"
+	"github.com/dikletscode/isyana-store/services/review"
+	"github.com/dikletscode/isyana-store/services/seller"
+	"github.com/dikletscode/isyana-store/services/transaction"
+)
+
+// NewRouter mounts every service on a fresh router. It does not touch the
+// database, so the whole API can be built in tests with db.PG pointed anywhere.
+func NewRouter() *router.Router {
+	rt := router.New()
+	store := storage.NewLocalStore(os.Getenv("STORAGE_DIR"))
+
+	payment.Register(payment.NewMockGateway(mockPaymentDelay(), []byte(os.Getenv("MOCK_PAYMENT_WEBHOOK_SECRET")), transaction.HandlePaymentEvent))
+
+	rt.Get("/media/{key...}", media.Handler(store))
+	auth.AuthRouters(rt, store)
+	order.SellerRouter(rt)
+	order.CartRouter(rt)
+	seller.SellerRouter(rt, store)
+	seller.VocuherRoute(rt)
+	seller.AdminRouter(rt)
+	seller.ShopRouter(rt)
+	category.CategoryRouter(rt)
+	review.ReviewRouter(rt, store)
+	transaction.SellerRouter(rt, store)
+
+	return rt
+}
+
+// mockPaymentDelay is how long mock_delay payments stay pending, MOCK_PAYMENT_DELAY
+// takes a Go duration such as "30s".
+func mockPaymentDelay() time.Duration {
+	delay, err := time.ParseDuration(os.Getenv("MOCK_PAYMENT_DELAY"))
+	if err != nil {
+		return 5 * time.Second
+	}
+	return delay
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/router"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The checkout tests run the whole API against a real database, they are
+// skipped unless TEST_DATABASE_URL points at one. Every run registers its own
+// users, so the database can be reused.
+func testRouter(t *testing.T) *router.Router {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	t.Setenv("SECRET_TOKEN", "checkout-test-secret")
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	if err = db.MigrateUp(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+	db.PG = pool
+	return NewRouter()
+}
+
+type apiResponse struct {
+	Status string          `json:"status"`
+	Data   json.RawMessage `json:"data"`
+	Errors *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// call sends a JSON request and decodes the data of a successful response into out.
+func call(t *testing.T, rt *router.Router, method string, path string, accessToken string, body any, out any) {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+
+	var resp apiResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s %s: %d %s", method, path, rec.Code, rec.Body.String())
+	}
+	if resp.Status != "success" {
+		t.Fatalf("%s %s: %d %s", method, path, rec.Code, rec.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// signUp registers and logs in a new user, sellers get a token carrying the seller role.
+func signUp(t *testing.T, rt *router.Router, prefix string, asSeller bool) tokens {
+	t.Helper()
+	credentials := map[string]string{
+		"username": prefix + "_" + uuid.New().String()[:8],
+		"password": "Checkout-test1",
+	}
+	call(t, rt, "POST", "/register", "", credentials, nil)
+
+	var tok tokens
+	call(t, rt, "POST", "/login", "", credentials, &tok)
+	if asSeller {
+		call(t, rt, "POST", "/profile/seller", tok.AccessToken, nil, nil)
+		call(t, rt, "POST", "/token/refresh", "", map[string]string{"refresh_token": tok.RefreshToken}, &tok)
+	}
+	return tok
+}
+
+type checkout struct {
+	Id                string `json:"id"`
+	PreDiscountAmount int    `json:"pre_discount_amount"`
+	FinalAmount       int    `json:"final_amount"`
+	PaymentStatus     string `json:"payment_status"`
+	// Lines only come with GET /transaction/{id}
+	Lines []struct {
+		OrderId string `json:"order_id"`
+	} `json:"lines"`
+}
+
+// buy puts quantity of the product in the buyer's cart and checks the cart out.
+func buy(t *testing.T, rt *router.Router, accessToken string, productId string, quantity int) checkout {
+	t.Helper()
+	var cart struct {
+		Items []struct {
+			OrderId   string `json:"order_id"`
+			ProductId string `json:"product_id"`
+		} `json:"items"`
+	}
+	call(t, rt, "POST", "/cart/items", accessToken, map[string]any{"product_id": productId, "quantity": quantity}, &cart)
+
+	var orderIds []string
+	for _, item := range cart.Items {
+		if item.ProductId == productId {
+			orderIds = append(orderIds, item.OrderId)
+		}
+	}
+	if len(orderIds) != 1 {
+		t.Fatalf("cart holds %d lines of the product, want 1", len(orderIds))
+	}
+
+	var txn checkout
+	call(t, rt, "POST", "/transaction", accessToken, map[string]any{
+		"payment_method":   "mock_success",
+		"payment_provider": "mock",
+		"order_id":         orderIds,
+	}, &txn)
+	return txn
+}
+
+func TestRepeatCheckoutOnlyChargesNewOrders(t *testing.T) {
+	rt := testRouter(t)
+
+	seller := signUp(t, rt, "seller", true)
+	var product struct {
+		Id string `json:"id"`
+	}
+	call(t, rt, "POST", "/product", seller.AccessToken, map[string]any{
+		"name":        "Checkout test mug",
+		"description": "A mug bought twice",
+		"price":       2500,
+		"stock":       10,
+	}, &product)
+
+	buyer := signUp(t, rt, "buyer", false)
+
+	first := buy(t, rt, buyer.AccessToken, product.Id, 2)
+	if first.PaymentStatus != "paid" {
+		t.Fatalf("first checkout is %s, want paid", first.PaymentStatus)
+	}
+	if first.PreDiscountAmount != 5000 || first.FinalAmount != 5000 {
+		t.Fatalf("first checkout charged %d of %d, want 5000", first.FinalAmount, first.PreDiscountAmount)
+	}
+
+	// the paid orders of the first checkout must not be charged again
+	second := buy(t, rt, buyer.AccessToken, product.Id, 1)
+	if second.PaymentStatus != "paid" {
+		t.Fatalf("second checkout is %s, want paid", second.PaymentStatus)
+	}
+	if second.PreDiscountAmount != 2500 || second.FinalAmount != 2500 {
+		t.Fatalf("second checkout charged %d of %d, want 2500", second.FinalAmount, second.PreDiscountAmount)
+	}
+
+	for _, c := range []struct {
+		txn   checkout
+		total int
+	}{{first, 5000}, {second, 2500}} {
+		var reloaded checkout
+		call(t, rt, "GET", "/transaction/"+c.txn.Id, buyer.AccessToken, nil, &reloaded)
+		if reloaded.FinalAmount != c.total || len(reloaded.Lines) != 1 {
+			t.Fatalf("transaction %s reads %d over %d lines, want %d over 1", c.txn.Id, reloaded.FinalAmount, len(reloaded.Lines), c.total)
+		}
+	}
+}
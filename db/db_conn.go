@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -15,7 +16,7 @@ var PG *pgxpool.Pool
 // 	return pg
 // }
 
-func DBConnect() {
+func connect() {
 
 	dbConfig, err := pgxpool.ParseConfig(os.Getenv("DATABASE_URL"))
 	if err != nil {
@@ -31,3 +32,61 @@ func DBConnect() {
 	}
 
 }
+
+// DBConnect opens the pool and applies pending migrations, unless
+// AUTO_MIGRATE=false in which case `migrate up` has to be run by hand.
+func DBConnect() {
+	connect()
+
+	if os.Getenv("AUTO_MIGRATE") == "false" {
+		return
+	}
+	err := MigrateUp(context.Background(), PG)
+	if err != nil {
+		log.Fatalf("Unable to migrate database: %v\n", err)
+	}
+}
+
+// RunMigrateCommand handles `migrate up`, `migrate down [steps]` and `migrate status`.
+func RunMigrateCommand(args []string) error {
+	connect()
+	defer PG.Close()
+
+	ctx := context.Background()
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [steps]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		return MigrateUp(ctx, PG)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+			steps = n
+		}
+		return MigrateDown(ctx, PG, steps)
+	case "status":
+		status, err := GetMigrationStatus(ctx, PG)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Modified {
+				state += " (modified since applied)"
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
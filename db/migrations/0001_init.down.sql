@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS order_transactions;
+DROP TABLE IF EXISTS vouchers;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS categories;
+DROP TABLE IF EXISTS users;
@@ -1,8 +1,5 @@
-package db
-
-// 1
-var schemaUser = `
-	CREATE TABLE IF NOT EXISTS users (
+-- user_type => B OR S (BUYER OR SELLER) + R (RATING) + 1..10
+CREATE TABLE IF NOT EXISTS users (
 	id UUID PRIMARY KEY,
 	full_name VARCHAR(50),
 	username VARCHAR(50) NOT NULL UNIQUE,
@@ -11,21 +8,16 @@ var schemaUser = `
 	shipping_address VARCHAR(255),
 	user_type CHAR(3) NOT NULL DEFAULT 'BR1',
 	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
-  	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
-);` //user type => B OR S (BUYYER OR SELLER) + R (RATING) + 1..10
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
 
-// 2
-var schemaCatagories = `CREATE TABLE IF NOT EXISTS categories (
+CREATE TABLE IF NOT EXISTS categories (
 	id UUID PRIMARY KEY,
 	name VARCHAR(50) NOT NULL,
 	components VARCHAR(100) NOT NULL
-);`
-
-var insertCatagories = `INSERT INTO categories (id, name, components) VALUES ($1, $2, $3)
-`
+);
 
-// 3
-var schemaProduct = `CREATE TABLE IF NOT EXISTS products (
+CREATE TABLE IF NOT EXISTS products (
 	id UUID PRIMARY KEY,
 	name VARCHAR(100) NOT NULL,
 	description VARCHAR(200),
@@ -34,26 +26,32 @@ var schemaProduct = `CREATE TABLE IF NOT EXISTS products (
 	category_id UUID REFERENCES categories(id) ON DELETE SET NULL,
 	seller_id UUID NOT NULL REFERENCES users(id),
 	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
-  	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
-  	deleted_at TIMESTAMPTZ DEFAULT NULL
-);`
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	deleted_at TIMESTAMPTZ DEFAULT NULL
+);
 
-// 4
-var schemaOrders = `CREATE TABLE IF NOT EXISTS orders (
+-- purchase_source => direct/cart
+-- purchase_status => IN_CART/PENDING/IN_PROGRSS/ON_HOLD/SHIPPED/DELIVERED/RETURNED
+CREATE TABLE IF NOT EXISTS orders (
 	id UUID PRIMARY KEY,
 	product_id UUID NOT NULL REFERENCES products(id),
-	user_id UUID NOT NULL  REFERENCES users(id),
+	user_id UUID NOT NULL REFERENCES users(id),
 	note VARCHAR(150),
 	purchase_source VARCHAR(15) NOT NULL DEFAULT 'direct',
 	purchase_status VARCHAR(15) NOT NULL,
 	quantity INTEGER NOT NULL DEFAULT 0,
 	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
-  	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
-);` /*  purchase_source => direct/cart
-purchase_status => IN_CART/PENDING/IN_PROGRSS/ON_HOLD/SHIPPED/DELIVERED/RETURNED
-*/
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
 
-var schemaTransaction = `CREATE TABLE IF NOT EXISTS transactions (
+-- addToOrder relies on this name to detect a product already sitting in the cart.
+-- Databases made by hand before the migrations have a full unique constraint of
+-- the same name, which would block buying a product twice.
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_product_id_user_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS orders_product_id_user_id_key
+	ON orders (product_id, user_id) WHERE purchase_status = 'IN_CART';
+
+CREATE TABLE IF NOT EXISTS transactions (
 	id UUID PRIMARY KEY,
 	discount INTEGER NOT NULL DEFAULT 0,
 	pre_discount_amount INTEGER NOT NULL DEFAULT 0,
@@ -61,34 +59,33 @@ var schemaTransaction = `CREATE TABLE IF NOT EXISTS transactions (
 	invoice VARCHAR(255) NOT NULL,
 	payment_method VARCHAR(50) NOT NULL,
 	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
-  	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
-);`
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
 
-var schemaVoucher = `CREATE TABLE IF NOT EXISTS vouchers (
+-- type => VOS = SINGLE = Product discount
+--         VOM = MULTIPLE = Products discount
+--         VOC = COMBINE = Some Product discount
+CREATE TABLE IF NOT EXISTS vouchers (
 	id UUID PRIMARY KEY,
 	name VARCHAR(100) NOT NULL,
+	description VARCHAR(200),
 	type CHAR(3) NOT NULL,
 	status CHAR(1) NOT NULL DEFAULT 'A',
 	discount_percentage SMALLINT DEFAULT 0,
 	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
-  	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
 	deleted_at TIMESTAMPTZ DEFAULT NULL
-);`
-var schemaOrderTransaction = `CREATE TABLE IF NOT EXISTS order_transactions (
+);
+
+-- Databases created by hand before migrations existed are missing this column.
+ALTER TABLE vouchers ADD COLUMN IF NOT EXISTS description VARCHAR(200);
+
+CREATE TABLE IF NOT EXISTS order_transactions (
 	id UUID PRIMARY KEY,
 	orders_id UUID NOT NULL REFERENCES orders(id),
 	transaction_id UUID NOT NULL REFERENCES transactions(id),
 	voucher_id UUID REFERENCES vouchers(id),
 	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
 	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
-  	deleted_at TIMESTAMPTZ DEFAULT NULL
-);`
-
-/* TYPE
-V0S = SINGLE = Product discount
-V0M = MULTIPLE = Products discount
-V0C = COMBINE = Some Product discount
-
-
-
-*/
+	deleted_at TIMESTAMPTZ DEFAULT NULL
+);
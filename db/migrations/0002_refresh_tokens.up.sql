@@ -0,0 +1,14 @@
+-- A family is one login session; every rotation adds a token to the same family
+-- and revoking the family logs the session out.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id UUID PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id),
+	family_id UUID NOT NULL,
+	token_hash CHAR(64) NOT NULL UNIQUE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ DEFAULT NULL,
+	revoked_at TIMESTAMPTZ DEFAULT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
@@ -0,0 +1,4 @@
+-- The first character of user_type is the account role: B = buyer, S = seller, A = admin.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_user_type_role_check;
+ALTER TABLE users ADD CONSTRAINT users_user_type_role_check
+	CHECK (substr(user_type, 1, 1) IN ('B', 'S', 'A'));
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS order_transactions_voucher_id_idx;
+DROP TABLE IF EXISTS voucher_products;
@@ -0,0 +1,9 @@
+-- Products a voucher can be redeemed against. VOS vouchers have exactly one row,
+-- VOM and VOC vouchers have several.
+CREATE TABLE IF NOT EXISTS voucher_products (
+	voucher_id UUID NOT NULL REFERENCES vouchers(id) ON DELETE CASCADE,
+	product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	PRIMARY KEY (voucher_id, product_id)
+);
+
+CREATE INDEX IF NOT EXISTS order_transactions_voucher_id_idx ON order_transactions (voucher_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS order_status_history;
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_purchase_status_check;
@@ -0,0 +1,19 @@
+-- Checkout used to write COMPLETED, which never was a valid status; those orders
+-- were paid and are waiting on the seller.
+UPDATE orders SET purchase_status = 'IN_PROGRESS' WHERE purchase_status IN ('COMPLETED', 'IN_PROGRSS');
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_purchase_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_purchase_status_check
+	CHECK (purchase_status IN ('IN_CART', 'PENDING', 'IN_PROGRESS', 'ON_HOLD', 'SHIPPED', 'DELIVERED', 'RETURNED'));
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+	id UUID PRIMARY KEY,
+	order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+	from_status VARCHAR(15) NOT NULL,
+	to_status VARCHAR(15) NOT NULL,
+	actor VARCHAR(10) NOT NULL CHECK (actor IN ('buyer', 'seller', 'system')),
+	actor_id UUID REFERENCES users(id),
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_status_history_order_id_idx ON order_status_history (order_id, created_at);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS transactions_payment_intent_key;
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_payment_status_check;
+ALTER TABLE transactions
+	DROP COLUMN IF EXISTS payment_status,
+	DROP COLUMN IF EXISTS payment_intent_id,
+	DROP COLUMN IF EXISTS payment_provider;
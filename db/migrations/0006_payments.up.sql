@@ -0,0 +1,14 @@
+-- Transactions created before payments existed were charged on the spot.
+ALTER TABLE transactions
+	ADD COLUMN IF NOT EXISTS payment_provider VARCHAR(30) NOT NULL DEFAULT 'mock',
+	ADD COLUMN IF NOT EXISTS payment_intent_id VARCHAR(100),
+	ADD COLUMN IF NOT EXISTS payment_status VARCHAR(15) NOT NULL DEFAULT 'paid';
+
+ALTER TABLE transactions ALTER COLUMN payment_status SET DEFAULT 'pending';
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_payment_status_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_payment_status_check
+	CHECK (payment_status IN ('pending', 'authorized', 'paid', 'failed', 'refunded'));
+
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_payment_intent_key
+	ON transactions (payment_provider, payment_intent_id);
@@ -0,0 +1,15 @@
+-- Raw provider notifications. The unique key makes redelivered events a no-op.
+CREATE TABLE IF NOT EXISTS payment_events (
+	id UUID PRIMARY KEY,
+	provider VARCHAR(30) NOT NULL,
+	event_id VARCHAR(100) NOT NULL,
+	event_type VARCHAR(50) NOT NULL,
+	intent_id VARCHAR(100) NOT NULL,
+	status VARCHAR(15) NOT NULL,
+	payload JSONB NOT NULL,
+	received_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	processed_at TIMESTAMPTZ DEFAULT NULL,
+	UNIQUE (provider, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS payment_events_intent_idx ON payment_events (provider, intent_id);
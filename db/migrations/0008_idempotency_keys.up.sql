@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id UUID NOT NULL REFERENCES users(id),
+	idempotency_key VARCHAR(255) NOT NULL,
+	fingerprint CHAR(64) NOT NULL,
+	status VARCHAR(12) NOT NULL DEFAULT 'processing' CHECK (status IN ('processing', 'completed')),
+	response_code INTEGER,
+	response_content_type VARCHAR(100),
+	response_body BYTEA,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	completed_at TIMESTAMPTZ DEFAULT NULL,
+	PRIMARY KEY (user_id, idempotency_key)
+);
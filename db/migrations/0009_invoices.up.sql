@@ -0,0 +1,37 @@
+-- One row per year. Numbers are taken with UPDATE ... RETURNING inside the
+-- transaction that issues the invoice, so a rollback gives the number back and
+-- the sequence stays gap-free.
+CREATE TABLE IF NOT EXISTS invoice_sequences (
+	year INTEGER PRIMARY KEY,
+	last_number INTEGER NOT NULL
+);
+
+-- Prices are tax inclusive; tax is the share of total that is tax at tax_rate.
+CREATE TABLE IF NOT EXISTS invoices (
+	id UUID PRIMARY KEY,
+	transaction_id UUID NOT NULL UNIQUE REFERENCES transactions(id),
+	invoice_number VARCHAR(30) NOT NULL UNIQUE,
+	year INTEGER NOT NULL,
+	sequence INTEGER NOT NULL,
+	subtotal INTEGER NOT NULL,
+	discount INTEGER NOT NULL,
+	tax_rate NUMERIC(5, 2) NOT NULL,
+	tax INTEGER NOT NULL,
+	total INTEGER NOT NULL,
+	buyer_name VARCHAR(50),
+	shipping_address VARCHAR(255),
+	issued_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	UNIQUE (year, sequence)
+);
+
+CREATE TABLE IF NOT EXISTS invoice_items (
+	id UUID PRIMARY KEY,
+	invoice_id UUID NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+	order_id UUID NOT NULL REFERENCES orders(id),
+	description VARCHAR(100) NOT NULL,
+	quantity INTEGER NOT NULL,
+	unit_price INTEGER NOT NULL,
+	amount INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS invoice_items_invoice_id_idx ON invoice_items (invoice_id);
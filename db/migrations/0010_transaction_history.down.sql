@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS transactions_created_at_idx;
+DROP INDEX IF EXISTS orders_user_id_idx;
+DROP INDEX IF EXISTS order_transactions_transaction_id_idx;
+DROP INDEX IF EXISTS order_transactions_orders_id_idx;
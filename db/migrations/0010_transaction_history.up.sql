@@ -0,0 +1,5 @@
+-- Buyers list their transactions by walking orders -> order_transactions.
+CREATE INDEX IF NOT EXISTS order_transactions_orders_id_idx ON order_transactions (orders_id);
+CREATE INDEX IF NOT EXISTS order_transactions_transaction_id_idx ON order_transactions (transaction_id);
+CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id);
+CREATE INDEX IF NOT EXISTS transactions_created_at_idx ON transactions (created_at DESC, id DESC);
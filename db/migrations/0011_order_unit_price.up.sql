@@ -0,0 +1,8 @@
+-- The price a line was bought at, written when checkout starts. Cart lines have
+-- no snapshot yet and are priced from products.price.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS unit_price INTEGER;
+
+-- Best effort for orders bought before snapshots existed.
+UPDATE orders o SET unit_price = p.price::INTEGER
+FROM products p
+WHERE p.id = o.product_id AND o.purchase_status <> 'IN_CART' AND o.unit_price IS NULL;
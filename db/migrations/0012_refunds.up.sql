@@ -0,0 +1,23 @@
+-- One row per refund sent to the payment provider. A transaction can have
+-- several partial refunds, their amounts never add up to more than final_amount.
+CREATE TABLE IF NOT EXISTS refunds (
+	id UUID PRIMARY KEY,
+	transaction_id UUID NOT NULL REFERENCES transactions(id),
+	provider_refund_id VARCHAR(100),
+	amount INTEGER NOT NULL CHECK (amount >= 0),
+	reason VARCHAR(200),
+	restock BOOLEAN NOT NULL DEFAULT false,
+	created_by UUID REFERENCES users(id),
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS refunds_transaction_id_idx ON refunds (transaction_id);
+
+-- The order lines a refund covers, an order is refunded at most once.
+CREATE TABLE IF NOT EXISTS refund_items (
+	refund_id UUID NOT NULL REFERENCES refunds(id) ON DELETE CASCADE,
+	order_id UUID NOT NULL UNIQUE REFERENCES orders(id),
+	quantity INTEGER NOT NULL,
+	amount INTEGER NOT NULL,
+	PRIMARY KEY (refund_id, order_id)
+);
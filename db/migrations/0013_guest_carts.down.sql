@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS orders_user_id_in_cart_idx;
+DROP TABLE IF EXISTS guest_cart_items;
+DROP TABLE IF EXISTS guest_carts;
@@ -0,0 +1,18 @@
+-- Carts of visitors who are not logged in. The cart id doubles as the bearer
+-- token the client sends in X-Cart-Id; on login the items move into orders.
+CREATE TABLE IF NOT EXISTS guest_carts (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS guest_cart_items (
+	cart_id UUID NOT NULL REFERENCES guest_carts(id) ON DELETE CASCADE,
+	product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	note VARCHAR(150),
+	quantity INTEGER NOT NULL CHECK (quantity > 0),
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (cart_id, product_id)
+);
+
+CREATE INDEX IF NOT EXISTS orders_user_id_in_cart_idx ON orders (user_id) WHERE purchase_status = 'IN_CART';
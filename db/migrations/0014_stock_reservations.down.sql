@@ -0,0 +1,7 @@
+-- Held stock goes back to being taken up front.
+UPDATE products p SET stock = p.stock - r.quantity
+FROM (SELECT product_id, SUM(quantity) AS quantity FROM stock_reservations WHERE status = 'active' GROUP BY product_id) r
+WHERE r.product_id = p.id;
+
+DROP VIEW IF EXISTS product_availability;
+DROP TABLE IF EXISTS stock_reservations;
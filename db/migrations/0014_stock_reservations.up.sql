@@ -0,0 +1,44 @@
+-- Stock held for orders between checkout and payment capture. An active row
+-- keeps its quantity out of what others can buy; capture consumes it (and only
+-- then products.stock goes down), a failed or expired payment releases it.
+CREATE TABLE IF NOT EXISTS stock_reservations (
+	id UUID PRIMARY KEY,
+	product_id UUID NOT NULL REFERENCES products(id),
+	order_id UUID NOT NULL REFERENCES orders(id),
+	transaction_id UUID NOT NULL REFERENCES transactions(id),
+	quantity INTEGER NOT NULL CHECK (quantity > 0),
+	status VARCHAR(10) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'consumed', 'released', 'expired')),
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	closed_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS stock_reservations_active_order_key
+	ON stock_reservations (order_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS stock_reservations_active_product_idx
+	ON stock_reservations (product_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS stock_reservations_active_expiry_idx
+	ON stock_reservations (expires_at) WHERE status = 'active';
+
+CREATE OR REPLACE VIEW product_availability AS
+SELECT p.id AS product_id, p.stock, COALESCE(r.reserved, 0) AS reserved, p.stock - COALESCE(r.reserved, 0) AS available
+FROM products p
+LEFT JOIN (
+	SELECT product_id, SUM(quantity)::INTEGER AS reserved
+	FROM stock_reservations WHERE status = 'active'
+	GROUP BY product_id
+) r ON r.product_id = p.id;
+
+-- Checkouts still waiting for their payment had their stock taken at checkout.
+-- Give it back and hold it as a reservation instead, so capture and failure
+-- treat them like new ones.
+INSERT INTO stock_reservations (id, product_id, order_id, transaction_id, quantity, expires_at)
+SELECT gen_random_uuid(), o.product_id, o.id, t.id, o.quantity, now() + interval '15 minutes'
+FROM transactions t
+JOIN order_transactions ot ON ot.transaction_id = t.id
+JOIN orders o ON o.id = ot.orders_id
+WHERE t.payment_status IN ('pending', 'authorized') AND o.purchase_status = 'PENDING' AND o.quantity > 0;
+
+UPDATE products p SET stock = p.stock + r.quantity
+FROM (SELECT product_id, SUM(quantity) AS quantity FROM stock_reservations GROUP BY product_id) r
+WHERE r.product_id = p.id;
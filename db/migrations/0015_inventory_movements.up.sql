@@ -0,0 +1,40 @@
+-- Append-only history of stock changes. delta moves products.stock, reserved
+-- moves the stock held by checkouts (see stock_reservations) and leaves
+-- products.stock alone. Summing delta per product gives products.stock back.
+CREATE TABLE IF NOT EXISTS inventory_movements (
+	id UUID PRIMARY KEY,
+	product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	reason VARCHAR(15) NOT NULL CHECK (reason IN ('restock', 'sale', 'refund', 'adjustment', 'reservation')),
+	delta INTEGER NOT NULL DEFAULT 0,
+	reserved INTEGER NOT NULL DEFAULT 0,
+	stock_after INTEGER NOT NULL,
+	reference_id UUID,
+	actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+	note VARCHAR(200),
+	created_at TIMESTAMPTZ DEFAULT clock_timestamp() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS inventory_movements_product_idx ON inventory_movements (product_id, created_at DESC, id DESC);
+
+-- Rows only go away together with their product, through the cascade.
+CREATE OR REPLACE FUNCTION inventory_movements_append_only() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' AND pg_trigger_depth() > 1 THEN
+		RETURN OLD;
+	END IF;
+	RAISE EXCEPTION 'inventory_movements is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS inventory_movements_append_only ON inventory_movements;
+CREATE TRIGGER inventory_movements_append_only
+	BEFORE UPDATE OR DELETE ON inventory_movements
+	FOR EACH ROW EXECUTE FUNCTION inventory_movements_append_only();
+
+-- Opening balance so the ledger adds up for products created before it existed.
+INSERT INTO inventory_movements (id, product_id, reason, delta, reserved, stock_after, note)
+SELECT gen_random_uuid(), p.id, 'adjustment', p.stock, COALESCE(r.reserved, 0), p.stock, 'opening balance'
+FROM products p
+LEFT JOIN (
+	SELECT product_id, SUM(quantity)::INTEGER AS reserved FROM stock_reservations WHERE status = 'active' GROUP BY product_id
+) r ON r.product_id = p.id;
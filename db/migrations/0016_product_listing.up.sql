@@ -0,0 +1,5 @@
+-- GET /product pages through live products with a (sort column, id) keyset.
+CREATE INDEX IF NOT EXISTS products_created_at_idx ON products (created_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS products_price_idx ON products (price, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS products_name_idx ON products (name, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS products_seller_id_idx ON products (seller_id) WHERE deleted_at IS NULL;
@@ -0,0 +1,13 @@
+-- GET /product/search matches words through search_vector and falls back to
+-- trigram similarity on the name for misspelled queries.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- 'simple' keeps words as typed, the catalog mixes languages so no stemming.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector
+	GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', COALESCE(name, '')), 'A') ||
+		setweight(to_tsvector('simple', COALESCE(description, '')), 'B')
+	) STORED;
+
+CREATE INDEX IF NOT EXISTS products_search_vector_idx ON products USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS products_name_trgm_idx ON products USING GIN (name gin_trgm_ops);
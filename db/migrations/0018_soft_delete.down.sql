@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS orders_product_id_idx;
+DROP INDEX IF EXISTS vouchers_deleted_at_idx;
+DROP INDEX IF EXISTS products_deleted_at_idx;
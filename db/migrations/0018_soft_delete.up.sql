@@ -0,0 +1,5 @@
+-- The purge looks up old soft-deleted rows and whether an order still uses them,
+-- order_transactions.voucher_id is indexed since 0004.
+CREATE INDEX IF NOT EXISTS products_deleted_at_idx ON products (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS vouchers_deleted_at_idx ON vouchers (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS orders_product_id_idx ON orders (product_id);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS products_category_id_idx;
+DROP INDEX IF EXISTS categories_parent_id_idx;
+DROP INDEX IF EXISTS categories_slug_key;
+ALTER TABLE categories DROP CONSTRAINT IF EXISTS categories_not_own_parent;
+ALTER TABLE categories
+	DROP COLUMN IF EXISTS updated_at,
+	DROP COLUMN IF EXISTS created_at,
+	DROP COLUMN IF EXISTS slug,
+	DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,24 @@
+-- Categories nest through parent_id and are addressed by a unique slug.
+ALTER TABLE categories
+	ADD COLUMN IF NOT EXISTS parent_id UUID REFERENCES categories(id) ON DELETE RESTRICT,
+	ADD COLUMN IF NOT EXISTS slug VARCHAR(60),
+	ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL;
+
+ALTER TABLE categories DROP CONSTRAINT IF EXISTS categories_not_own_parent;
+ALTER TABLE categories ADD CONSTRAINT categories_not_own_parent CHECK (parent_id <> id);
+
+-- Existing rows get a slug from their name, duplicates are told apart by their id.
+UPDATE categories c SET slug = base.slug || CASE WHEN base.n > 1 THEN '-' || left(c.id::text, 8) ELSE '' END
+FROM (
+	SELECT id,
+	COALESCE(NULLIF(trim(BOTH '-' FROM lower(regexp_replace(name, '[^a-zA-Z0-9]+', '-', 'g'))), ''), 'category') AS slug,
+	count(*) OVER (PARTITION BY COALESCE(NULLIF(trim(BOTH '-' FROM lower(regexp_replace(name, '[^a-zA-Z0-9]+', '-', 'g'))), ''), 'category')) AS n
+	FROM categories
+) base
+WHERE base.id = c.id AND c.slug IS NULL;
+
+ALTER TABLE categories ALTER COLUMN slug SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS categories_slug_key ON categories (slug);
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id);
+CREATE INDEX IF NOT EXISTS products_category_id_idx ON products (category_id) WHERE deleted_at IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS photo_thumbnail;
+DROP TABLE IF EXISTS product_images;
@@ -0,0 +1,18 @@
+-- Uploaded files live in the blob store, rows keep their keys.
+CREATE TABLE IF NOT EXISTS product_images (
+	id UUID PRIMARY KEY,
+	product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	key VARCHAR(255) NOT NULL,
+	thumbnail_key VARCHAR(255) NOT NULL,
+	content_type VARCHAR(50) NOT NULL,
+	size INTEGER NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	-- deferred so a reorder can swap positions inside one transaction
+	CONSTRAINT product_images_position_key UNIQUE (product_id, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+-- users.photo holds the blob key of the avatar from now on.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS photo_thumbnail VARCHAR(255);
@@ -0,0 +1,26 @@
+-- Going back collapses every product to its stock total and loses the variants.
+DROP VIEW IF EXISTS variant_availability;
+
+ALTER TABLE inventory_movements DROP COLUMN IF EXISTS variant_id;
+
+DROP INDEX IF EXISTS stock_reservations_active_variant_idx;
+ALTER TABLE stock_reservations DROP COLUMN IF EXISTS variant_id;
+
+-- guest carts may hold several variants of one product, keep the first
+DELETE FROM guest_cart_items g USING guest_cart_items other
+WHERE other.cart_id = g.cart_id AND other.product_id = g.product_id AND other.variant_id < g.variant_id;
+ALTER TABLE guest_cart_items DROP CONSTRAINT IF EXISTS guest_cart_items_pkey;
+ALTER TABLE guest_cart_items ADD PRIMARY KEY (cart_id, product_id);
+ALTER TABLE guest_cart_items DROP COLUMN IF EXISTS variant_id;
+
+DROP INDEX IF EXISTS orders_variant_id_user_id_key;
+-- same for carts of users, this fails if such a line went through a checkout before
+DELETE FROM orders o USING orders other
+WHERE o.purchase_status = 'IN_CART' AND other.purchase_status = 'IN_CART'
+AND other.user_id = o.user_id AND other.product_id = o.product_id AND other.id < o.id;
+CREATE UNIQUE INDEX IF NOT EXISTS orders_product_id_user_id_key
+	ON orders (product_id, user_id) WHERE purchase_status = 'IN_CART';
+ALTER TABLE orders DROP COLUMN IF EXISTS variant_id;
+
+DROP TABLE IF EXISTS product_variants;
+DROP TABLE IF EXISTS product_options;
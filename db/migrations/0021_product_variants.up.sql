@@ -0,0 +1,74 @@
+-- A product sells as one or more variants. Option axes such as size or color
+-- list the values a variant picks from; a variant has its own SKU, stock and
+-- optionally its own price. products.stock stays the sum of its variants so
+-- product level availability keeps working.
+CREATE TABLE IF NOT EXISTS product_options (
+	product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	name VARCHAR(30) NOT NULL,
+	position INTEGER NOT NULL,
+	option_values TEXT[] NOT NULL,
+	PRIMARY KEY (product_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS product_variants (
+	id UUID PRIMARY KEY,
+	product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	seller_id UUID NOT NULL REFERENCES users(id),
+	sku VARCHAR(64),
+	options JSONB NOT NULL DEFAULT '{}',
+	price INTEGER CHECK (price > 0),
+	stock INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	deleted_at TIMESTAMPTZ DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS product_variants_product_id_idx ON product_variants (product_id);
+CREATE UNIQUE INDEX IF NOT EXISTS product_variants_options_key
+	ON product_variants (product_id, options) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS product_variants_sku_key
+	ON product_variants (seller_id, sku) WHERE sku IS NOT NULL AND deleted_at IS NULL;
+
+-- Every existing product becomes a single variant without options.
+INSERT INTO product_variants (id, product_id, seller_id, stock, created_at, updated_at)
+SELECT gen_random_uuid(), p.id, p.seller_id, p.stock, p.created_at, p.updated_at
+FROM products p
+WHERE NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id);
+
+-- Orders and cart lines point at the variant they buy.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS variant_id UUID REFERENCES product_variants(id);
+UPDATE orders o SET variant_id = v.id FROM product_variants v WHERE v.product_id = o.product_id AND o.variant_id IS NULL;
+ALTER TABLE orders ALTER COLUMN variant_id SET NOT NULL;
+
+DROP INDEX IF EXISTS orders_product_id_user_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS orders_variant_id_user_id_key
+	ON orders (variant_id, user_id) WHERE purchase_status = 'IN_CART';
+
+ALTER TABLE guest_cart_items ADD COLUMN IF NOT EXISTS variant_id UUID REFERENCES product_variants(id) ON DELETE CASCADE;
+UPDATE guest_cart_items g SET variant_id = v.id FROM product_variants v WHERE v.product_id = g.product_id AND g.variant_id IS NULL;
+ALTER TABLE guest_cart_items ALTER COLUMN variant_id SET NOT NULL;
+ALTER TABLE guest_cart_items DROP CONSTRAINT IF EXISTS guest_cart_items_pkey;
+ALTER TABLE guest_cart_items ADD PRIMARY KEY (cart_id, variant_id);
+
+ALTER TABLE stock_reservations ADD COLUMN IF NOT EXISTS variant_id UUID REFERENCES product_variants(id);
+UPDATE stock_reservations r SET variant_id = o.variant_id FROM orders o WHERE o.id = r.order_id AND r.variant_id IS NULL;
+ALTER TABLE stock_reservations ALTER COLUMN variant_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS stock_reservations_active_variant_idx
+	ON stock_reservations (variant_id) WHERE status = 'active';
+
+-- The ledger is append-only, the backfill is the one sanctioned rewrite. From
+-- here on stock_after is the stock of the variant rather than the product.
+ALTER TABLE inventory_movements ADD COLUMN IF NOT EXISTS variant_id UUID REFERENCES product_variants(id) ON DELETE CASCADE;
+ALTER TABLE inventory_movements DISABLE TRIGGER inventory_movements_append_only;
+UPDATE inventory_movements m SET variant_id = v.id FROM product_variants v WHERE v.product_id = m.product_id AND m.variant_id IS NULL;
+ALTER TABLE inventory_movements ENABLE TRIGGER inventory_movements_append_only;
+ALTER TABLE inventory_movements ALTER COLUMN variant_id SET NOT NULL;
+
+CREATE OR REPLACE VIEW variant_availability AS
+SELECT v.id AS variant_id, v.product_id, v.stock, COALESCE(r.reserved, 0) AS reserved, v.stock - COALESCE(r.reserved, 0) AS available
+FROM product_variants v
+LEFT JOIN (
+	SELECT variant_id, SUM(quantity)::INTEGER AS reserved
+	FROM stock_reservations WHERE status = 'active'
+	GROUP BY variant_id
+) r ON r.variant_id = v.id;
@@ -0,0 +1,11 @@
+ALTER TABLE users ALTER COLUMN user_type DROP DEFAULT;
+ALTER TABLE users ALTER COLUMN user_type TYPE CHAR(3) USING user_type || 'R1';
+ALTER TABLE users ALTER COLUMN user_type SET DEFAULT 'BR1';
+
+DROP VIEW IF EXISTS seller_reputation;
+DROP TRIGGER IF EXISTS reviews_rating_totals ON reviews;
+DROP FUNCTION IF EXISTS reviews_rating_totals();
+DROP TABLE IF EXISTS review_photos;
+DROP TABLE IF EXISTS reviews;
+ALTER TABLE products DROP COLUMN IF EXISTS rating_total;
+ALTER TABLE products DROP COLUMN IF EXISTS rating_count;
@@ -0,0 +1,73 @@
+-- A buyer reviews a product once, after an order for it was delivered.
+CREATE TABLE IF NOT EXISTS reviews (
+	id UUID PRIMARY KEY,
+	product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+	user_id UUID NOT NULL REFERENCES users(id),
+	order_id UUID NOT NULL REFERENCES orders(id),
+	rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+	body VARCHAR(2000),
+	reply VARCHAR(2000),
+	replied_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	UNIQUE (product_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_product_id_created_at_idx ON reviews (product_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+
+CREATE TABLE IF NOT EXISTS review_photos (
+	id UUID PRIMARY KEY,
+	review_id UUID NOT NULL REFERENCES reviews(id) ON DELETE CASCADE,
+	key VARCHAR(255) NOT NULL,
+	thumbnail_key VARCHAR(255) NOT NULL,
+	content_type VARCHAR(50) NOT NULL,
+	size INTEGER NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	UNIQUE (review_id, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+-- Products carry their rating totals so listings don't aggregate reviews per row.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS rating_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS rating_total INTEGER NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION reviews_rating_totals() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP IN ('UPDATE', 'DELETE') THEN
+		UPDATE products SET rating_count = rating_count - 1, rating_total = rating_total - OLD.rating
+		WHERE id = OLD.product_id;
+	END IF;
+	IF TG_OP IN ('INSERT', 'UPDATE') THEN
+		UPDATE products SET rating_count = rating_count + 1, rating_total = rating_total + NEW.rating
+		WHERE id = NEW.product_id;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS reviews_rating_totals ON reviews;
+CREATE TRIGGER reviews_rating_totals
+	AFTER INSERT OR DELETE OR UPDATE OF rating, product_id ON reviews
+	FOR EACH ROW EXECUTE FUNCTION reviews_rating_totals();
+
+-- A seller's reputation is the average of the reviews of all their products,
+-- deleted ones included, pulled towards 3 stars by five phantom reviews so a
+-- single review can't make or break a shop, then put on the 1 to 10 scale the
+-- rating digit of user_type used to promise. No reviews, no score.
+CREATE OR REPLACE VIEW seller_reputation AS
+SELECT p.seller_id,
+	SUM(p.rating_count)::INTEGER AS review_count,
+	ROUND(SUM(p.rating_total)::NUMERIC / NULLIF(SUM(p.rating_count), 0), 2)::FLOAT8 AS rating_average,
+	CASE WHEN SUM(p.rating_count) > 0 THEN
+		ROUND(1 + ((15 + SUM(p.rating_total))::NUMERIC / (5 + SUM(p.rating_count)) - 1) * 9 / 4, 1)::FLOAT8
+	END AS score
+FROM products p
+GROUP BY p.seller_id;
+
+-- user_type keeps the role alone, the rating part was never computed.
+ALTER TABLE users ALTER COLUMN user_type DROP DEFAULT;
+ALTER TABLE users ALTER COLUMN user_type TYPE CHAR(1) USING substr(user_type, 1, 1);
+ALTER TABLE users ALTER COLUMN user_type SET DEFAULT 'B';
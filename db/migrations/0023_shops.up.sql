@@ -0,0 +1,14 @@
+-- Shop settings of a seller, kept apart from users so the public storefront
+-- never has to read the private columns of an account.
+CREATE TABLE IF NOT EXISTS shops (
+	seller_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	name VARCHAR(100),
+	description VARCHAR(1000),
+	return_policy VARCHAR(2000),
+	banner VARCHAR(255),
+	banner_thumbnail VARCHAR(255),
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS shops_name_key ON shops (lower(name));
@@ -0,0 +1,6 @@
+-- cancelled orders have no place in the older status list, they are closed as returned
+UPDATE orders SET purchase_status = 'RETURNED' WHERE purchase_status = 'CANCELLED';
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_purchase_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_purchase_status_check
+	CHECK (purchase_status IN ('IN_CART', 'PENDING', 'IN_PROGRESS', 'ON_HOLD', 'SHIPPED', 'DELIVERED', 'RETURNED'));
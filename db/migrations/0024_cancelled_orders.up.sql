@@ -0,0 +1,21 @@
+-- A failed payment cancels its orders, the quantities go back to the cart as
+-- new lines. Orders used to go back to IN_CART themselves, still referenced by
+-- the failed transaction and its reservations, which made them impossible to
+-- remove from the cart or to fold into another line.
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_purchase_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_purchase_status_check
+	CHECK (purchase_status IN ('IN_CART', 'PENDING', 'IN_PROGRESS', 'ON_HOLD', 'SHIPPED', 'DELIVERED', 'RETURNED', 'CANCELLED'));
+
+-- cart lines left over from failed payments are cancelled and replaced the same way
+WITH stale AS (
+	UPDATE orders o SET purchase_status = 'CANCELLED', updated_at = now()
+	WHERE o.purchase_status = 'IN_CART'
+	AND (EXISTS (SELECT 1 FROM order_transactions ot WHERE ot.orders_id = o.id)
+		OR EXISTS (SELECT 1 FROM stock_reservations r WHERE r.order_id = o.id))
+	RETURNING o.id, o.product_id, o.variant_id, o.user_id, o.note, o.purchase_source, o.quantity
+), history AS (
+	INSERT INTO order_status_history (id, order_id, from_status, to_status, actor)
+	SELECT gen_random_uuid(), id, 'IN_CART', 'CANCELLED', 'system' FROM stale
+)
+INSERT INTO orders (id, product_id, variant_id, user_id, note, purchase_source, purchase_status, quantity)
+SELECT gen_random_uuid(), product_id, variant_id, user_id, note, purchase_source, 'IN_CART', quantity FROM stale;
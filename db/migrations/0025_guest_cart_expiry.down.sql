@@ -0,0 +1 @@
+DROP INDEX IF EXISTS guest_carts_updated_at_idx;
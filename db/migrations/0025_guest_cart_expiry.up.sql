@@ -0,0 +1,3 @@
+-- Guest carts expire once nobody wrote to them for GUEST_CART_TTL, the sweep
+-- looks them up by their last write.
+CREATE INDEX IF NOT EXISTS guest_carts_updated_at_idx ON guest_carts (updated_at);
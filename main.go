@@ -1,28 +1,37 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/dikletscode/isyana-store/api"
 	"github.com/dikletscode/isyana-store/db"
 	"github.com/dikletscode/isyana-store/pkg/utils"
-	"github.com/dikletscode/isyana-store/services/auth"
 	"github.com/dikletscode/isyana-store/services/order"
-	"github.com/dikletscode/isyana-store/services/seller"
 	"github.com/dikletscode/isyana-store/services/transaction"
 )
 
 func main() {
 	utils.LoadEnvFile()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := db.RunMigrateCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println("Error running migrations:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	db.DBConnect()
 
-	auth.AuthRouters()
-	order.SellerRouter()
-	seller.SellerRouter()
-	seller.VocuherRoute()
-	transaction.SellerRouter()
+	go transaction.SweepExpiredReservations(context.Background(), 30*time.Second)
+	go order.SweepExpiredGuestCarts(context.Background(), time.Hour)
 
-	err := http.ListenAndServe(":5000", nil)
+	err := http.ListenAndServe(":5000", api.NewRouter())
 	if err != nil {
 		fmt.Println("Error starting server:", err)
 	}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strings"
 
+	"github.com/dikletscode/isyana-store/db"
 	"github.com/dikletscode/isyana-store/pkg/httperrors"
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -19,24 +21,31 @@ const (
 	userCtxKey contextKey = "user"
 )
 
+// UserFromContext returns the claims of the caller, or nil on a route where
+// authentication is optional and no token was sent.
 func UserFromContext(ctx context.Context) jwt.MapClaims {
-	claims := ctx.Value(userCtxKey).(jwt.MapClaims)
+	claims, _ := ctx.Value(userCtxKey).(jwt.MapClaims)
 	return claims
 }
-func AuthMiddleware(next http.Handler, methodWhitelist []string) http.Handler {
+
+// isSessionRevoked reports whether the refresh token family behind an access
+// token was logged out or revoked. Lookup errors are treated as revoked.
+func isSessionRevoked(ctx context.Context, sessionId string) bool {
+	var revoked bool
+	query := `SELECT NOT EXISTS (SELECT 1 FROM refresh_tokens WHERE family_id = $1 AND revoked_at IS NULL)`
+	err := db.PG.QueryRow(ctx, query, sessionId).Scan(&revoked)
+	if err != nil {
+		log.Println(err.Error())
+		return true
+	}
+	return revoked
+}
+
+func AuthMiddleware(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Set("Content-Type", "application/json")
-		if len(methodWhitelist) >= 1 {
-			for _, method_white_list := range methodWhitelist {
-				if r.Method == method_white_list {
-					next.ServeHTTP(w, r)
-					return
-				}
-			}
-
-		}
 
 		headerAuth := r.Header.Get("Authorization")
 
@@ -87,6 +96,18 @@ func AuthMiddleware(next http.Handler, methodWhitelist []string) http.Handler {
 			return
 		}
 
+		sessionId, ok := claims["sid"].(string)
+		if !ok || isSessionRevoked(r.Context(), sessionId) {
+			httpError = &httperrors.Response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{Code: 401, Message: "Session has been revoked"},
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(httpError)
+			return
+		}
+
 		// if result == nil {
 		if token.Valid {
 			ctx := context.WithValue(r.Context(), userCtxKey, claims)
@@ -105,3 +126,18 @@ func AuthMiddleware(next http.Handler, methodWhitelist []string) http.Handler {
 	})
 
 }
+
+// OptionalAuth lets requests without an Authorization header through as guests.
+// A header that is sent still has to be valid.
+func OptionalAuth(next http.Handler) http.Handler {
+	authenticated := AuthMiddleware(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.Header().Set("Content-Type", "application/json")
+			next.ServeHTTP(w, r)
+			return
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/jackc/pgx/v5"
+)
+
+const IdempotencyHeader = "Idempotency-Key"
+
+// maxIdempotentBody caps the body of a request carrying an Idempotency-Key, it
+// is read whole to fingerprint it.
+const maxIdempotentBody = 1 << 20
+
+// responseRecorder passes the response through while keeping a copy to store.
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.code == 0 {
+		rec.code = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func writeIdempotencyError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	httpError := &httperrors.Response{
+		Status: "failed",
+		Data:   nil,
+		Errors: &httperrors.Errors{Code: code, Message: message},
+	}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(httpError)
+}
+
+// claimIdempotencyKey inserts the key as processing. A key older than 24 hours,
+// or stuck in processing for 5 minutes after a crash, is taken over. It reports
+// false when another request already owns the key.
+func claimIdempotencyKey(ctx context.Context, userId string, key string, fingerprint string) (bool, error) {
+	query := `INSERT INTO idempotency_keys (user_id, idempotency_key, fingerprint)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, idempotency_key) DO UPDATE SET
+		fingerprint = EXCLUDED.fingerprint, status = 'processing', response_code = NULL,
+		response_content_type = NULL, response_body = NULL, created_at = now(), completed_at = NULL
+	WHERE idempotency_keys.created_at < now() - interval '24 hours'
+		OR (idempotency_keys.status = 'processing' AND idempotency_keys.created_at < now() - interval '5 minutes')
+	RETURNING true`
+
+	var claimed bool
+	err := db.PG.QueryRow(ctx, query, userId, key, fingerprint).Scan(&claimed)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return claimed, err
+}
+
+// Idempotency must sit behind AuthMiddleware. A request carrying an
+// Idempotency-Key runs once per user and key; retries with the same method,
+// path and body get the stored response back, a different body gets 422 and a
+// retry while the first attempt is still running gets 409. 5xx responses are
+// not stored so the client can retry them.
+func Idempotency(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			writeIdempotencyError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		userId, _ := UserFromContext(r.Context())["jti"].(string)
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeIdempotencyError(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+			return
+		}
+		if err != nil {
+			writeIdempotencyError(w, http.StatusBadRequest, "Bad Request: Invalid input data")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		ctx := r.Context()
+		claimed, err := claimIdempotencyKey(ctx, userId, key, fingerprint)
+		if err != nil {
+			log.Println(err.Error())
+			writeIdempotencyError(w, http.StatusInternalServerError, httperrors.C500)
+			return
+		}
+
+		if !claimed {
+			var storedFingerprint, status string
+			var code *int
+			var contentType *string
+			var storedBody []byte
+			query := `SELECT fingerprint, status, response_code, response_content_type, response_body
+			FROM idempotency_keys WHERE user_id = $1 AND idempotency_key = $2`
+			err = db.PG.QueryRow(ctx, query, userId, key).Scan(&storedFingerprint, &status, &code, &contentType, &storedBody)
+			if err != nil {
+				log.Println(err.Error())
+				writeIdempotencyError(w, http.StatusInternalServerError, httperrors.C500)
+				return
+			}
+
+			switch {
+			case storedFingerprint != fingerprint:
+				writeIdempotencyError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+			case status != "completed" || code == nil:
+				writeIdempotencyError(w, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+			default:
+				if contentType != nil {
+					w.Header().Set("Content-Type", *contentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(*code)
+				w.Write(storedBody)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// the client may have gone away, the bookkeeping still has to happen
+		ctx = context.Background()
+		if rec.code == 0 || rec.code >= 500 {
+			_, err = db.PG.Exec(ctx, `DELETE FROM idempotency_keys WHERE user_id = $1 AND idempotency_key = $2`, userId, key)
+		} else {
+			query := `UPDATE idempotency_keys SET status = 'completed', response_code = $3,
+			response_content_type = $4, response_body = $5, completed_at = now()
+			WHERE user_id = $1 AND idempotency_key = $2`
+			_, err = db.PG.Exec(ctx, query, userId, key, rec.code, w.Header().Get("Content-Type"), rec.body.Bytes())
+		}
+		if err != nil {
+			log.Println(err.Error())
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	RoleBuyer  = "buyer"
+	RoleSeller = "seller"
+	RoleAdmin  = "admin"
+)
+
+// RolesForUserType maps users.user_type to the roles carried in the JWT.
+// Sellers keep the buyer role so they can still shop.
+func RolesForUserType(userType string) []string {
+	switch {
+	case strings.HasPrefix(userType, "A"):
+		return []string{RoleAdmin}
+	case strings.HasPrefix(userType, "S"):
+		return []string{RoleBuyer, RoleSeller}
+	default:
+		return []string{RoleBuyer}
+	}
+}
+
+func RolesFromContext(ctx context.Context) []string {
+	claims, ok := ctx.Value(userCtxKey).(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+	rawRoles, ok := claims["roles"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	roles := make([]string, 0, len(rawRoles))
+	for _, role := range rawRoles {
+		if r, ok := role.(string); ok {
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
+
+func HasRole(ctx context.Context, allowed ...string) bool {
+	for _, role := range RolesFromContext(ctx) {
+		for _, a := range allowed {
+			if role == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequireRole must sit behind AuthMiddleware and rejects callers holding none of roles.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasRole(r.Context(), roles...) {
+				w.Header().Set("Content-Type", "application/json")
+				httpError := &httperrors.Response{
+					Status: "failed",
+					Data:   nil,
+					Errors: &httperrors.Errors{Code: 403, Message: "Forbidden: requires role " + strings.Join(roles, " or ")},
+				}
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(httpError)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
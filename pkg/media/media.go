@@ -0,0 +1,273 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	_ "image/gif"
+
+	"github.com/dikletscode/isyana-store/pkg/router"
+	"github.com/dikletscode/isyana-store/pkg/storage"
+)
+
+// ThumbnailSize is the longest edge of a thumbnail in pixels.
+const ThumbnailSize = 320
+
+// maxPixels keeps a small file that decodes to a huge bitmap from eating memory.
+const maxPixels = 40_000_000
+
+var (
+	ErrUnsupportedType = errors.New("only JPEG, PNG and GIF images are accepted")
+	ErrTooLarge        = errors.New("file is too large")
+	ErrTooManyPixels   = errors.New("image dimensions are too large")
+	ErrNoFile          = errors.New("no file uploaded")
+	ErrTooManyFiles    = errors.New("too many files uploaded")
+)
+
+// publicPrefixes are the blob key prefixes Handler serves, everything else in
+// the store, invoices for one, stays private.
+var publicPrefixes = []string{"products/", "avatars/", "reviews/", "shops/"}
+
+// MaxUploadBytes is the size limit of a single upload, MAX_UPLOAD_BYTES
+// defaults to 5 MiB.
+func MaxUploadBytes() int64 {
+	limit, err := strconv.ParseInt(os.Getenv("MAX_UPLOAD_BYTES"), 10, 64)
+	if err != nil || limit <= 0 {
+		return 5 << 20
+	}
+	return limit
+}
+
+// Files parses a multipart request and returns the files sent under field,
+// at most maxFiles of MaxUploadBytes each. Callers remove the parsed form with
+// r.MultipartForm.RemoveAll once done.
+func Files(w http.ResponseWriter, r *http.Request, field string, maxFiles int) ([]*multipart.FileHeader, error) {
+	limit := MaxUploadBytes()
+	r.Body = http.MaxBytesReader(w, r.Body, limit*int64(maxFiles)+1<<20)
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, ErrTooLarge
+		}
+		return nil, err
+	}
+
+	files := r.MultipartForm.File[field]
+	switch {
+	case len(files) == 0:
+		return nil, ErrNoFile
+	case len(files) > maxFiles:
+		return nil, ErrTooManyFiles
+	}
+	for _, file := range files {
+		if file.Size > limit {
+			return nil, ErrTooLarge
+		}
+	}
+	return files, nil
+}
+
+// StatusCode maps the errors of Files and Process to an HTTP status, 0 for
+// anything else.
+func StatusCode(err error) int {
+	switch err {
+	case ErrTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrUnsupportedType:
+		return http.StatusUnsupportedMediaType
+	case ErrTooManyPixels, ErrTooManyFiles:
+		return http.StatusUnprocessableEntity
+	case ErrNoFile:
+		return http.StatusBadRequest
+	}
+	return 0
+}
+
+// ProcessFile opens an uploaded file and runs it through Process.
+func ProcessFile(file *multipart.FileHeader) (*Image, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Process(f)
+}
+
+// Image is a validated upload with its thumbnail, both encoded in the format
+// the file came in, GIFs get a PNG thumbnail.
+type Image struct {
+	Data        []byte
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+	Thumbnail   []byte
+	ThumbExt    string
+}
+
+// Process reads an upload of at most MaxUploadBytes, checks it really is an
+// image from the content, not the name or the client's Content-Type, and
+// renders its thumbnail.
+func Process(r io.Reader) (*Image, error) {
+	limit := MaxUploadBytes()
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrTooLarge
+	}
+
+	img := Image{Data: data, ContentType: http.DetectContentType(data)}
+	switch img.ContentType {
+	case "image/jpeg":
+		img.Ext, img.ThumbExt = "jpg", "jpg"
+	case "image/png":
+		img.Ext, img.ThumbExt = "png", "png"
+	case "image/gif":
+		img.Ext, img.ThumbExt = "gif", "png"
+	default:
+		return nil, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooManyPixels
+	}
+	img.Width, img.Height = config.Width, config.Height
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	var thumb bytes.Buffer
+	small := thumbnail(decoded, ThumbnailSize)
+	if img.ThumbExt == "jpg" {
+		err = jpeg.Encode(&thumb, small, &jpeg.Options{Quality: 82})
+	} else {
+		err = png.Encode(&thumb, small)
+	}
+	if err != nil {
+		return nil, err
+	}
+	img.Thumbnail = thumb.Bytes()
+	return &img, nil
+}
+
+// thumbnail scales src down so its longest edge is at most size, averaging
+// the source pixels each target pixel covers. Smaller images are copied as is.
+func thumbnail(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+		return dst
+	}
+
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	tw, th = max(tw, 1), max(th, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := bounds.Min.Y+y*h/th, bounds.Min.Y+max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := bounds.Min.X+x*w/tw, bounds.Min.X+max((x+1)*w/tw, x*w/tw+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// Save stores the image and its thumbnail as {prefix}/{id}.{ext} and
+// {prefix}/{id}_thumb.{ext} and returns both keys.
+func Save(ctx context.Context, store storage.BlobStore, prefix string, id string, img *Image) (string, string, error) {
+	key := prefix + "/" + id + "." + img.Ext
+	thumbKey := prefix + "/" + id + "_thumb." + img.ThumbExt
+	if err := store.Put(ctx, key, bytes.NewReader(img.Data)); err != nil {
+		return "", "", err
+	}
+	if err := store.Put(ctx, thumbKey, bytes.NewReader(img.Thumbnail)); err != nil {
+		store.Delete(ctx, key)
+		return "", "", err
+	}
+	return key, thumbKey, nil
+}
+
+// URL is where Handler serves a blob key from.
+func URL(key string) string {
+	return "/media/" + key
+}
+
+func isPublic(key string) bool {
+	for _, prefix := range publicPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var contentTypes = map[string]string{
+	".jpg": "image/jpeg",
+	".png": "image/png",
+	".gif": "image/gif",
+}
+
+// Handler serves public blobs for routes shaped like /media/{key...}. Keys
+// embed a fresh id on every upload, so responses can be cached for good.
+func Handler(store storage.BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := router.Param(r, "key")
+		contentType, ok := contentTypes[path.Ext(key)]
+		if !ok || !isPublic(key) {
+			http.NotFound(w, r)
+			return
+		}
+
+		blob, err := store.Get(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+			return
+		}
+		defer blob.Close()
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		io.Copy(w, blob)
+	}
+}
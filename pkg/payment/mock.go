@@ -0,0 +1,197 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Payment methods understood by the mock gateway. Anything else behaves like
+// MockMethodSuccess.
+const (
+	MockMethodSuccess = "mock_success"
+	MockMethodFailure = "mock_failure"
+	MockMethodDelay   = "mock_delay"
+)
+
+// A notification notify rejects is delivered again up to mockDeliveryAttempts
+// times in all, waiting mockRetryDelay and then twice as long each time.
+const (
+	mockDeliveryAttempts = 6
+	mockRetryDelay       = 500 * time.Millisecond
+)
+
+// MockGateway is an in-memory provider for local development and tests.
+// mock_success authorizes straight away, mock_failure declines and mock_delay
+// stays pending until Delay has passed and then reports the authorization
+// through notify, like a real gateway calling its webhook. As with a webhook,
+// an error from notify gets the event redelivered later. Webhooks posted to
+// the app have to be signed with WebhookSecret.
+type MockGateway struct {
+	Delay         time.Duration
+	WebhookSecret []byte
+
+	mu      sync.Mutex
+	intents map[string]*Intent
+	notify  func(Event) error
+}
+
+func NewMockGateway(delay time.Duration, webhookSecret []byte, notify func(Event) error) *MockGateway {
+	return &MockGateway{
+		Delay:         delay,
+		WebhookSecret: webhookSecret,
+		intents:       map[string]*Intent{},
+		notify:        notify,
+	}
+}
+
+func (m *MockGateway) Name() string {
+	return "mock"
+}
+
+func (m *MockGateway) CreateIntent(ctx context.Context, req IntentRequest) (Intent, error) {
+	intent := &Intent{
+		Id:        "mock_pi_" + uuid.New().String(),
+		Reference: req.Reference,
+		Amount:    req.Amount,
+		Method:    req.Method,
+	}
+
+	switch req.Method {
+	case MockMethodFailure:
+		intent.Status = StatusFailed
+	case MockMethodDelay:
+		intent.Status = StatusPending
+	default:
+		intent.Status = StatusAuthorized
+	}
+
+	m.mu.Lock()
+	m.intents[intent.Id] = intent
+	m.mu.Unlock()
+
+	if intent.Status == StatusPending {
+		time.AfterFunc(m.Delay, func() { m.settle(intent.Id) })
+	}
+
+	return *intent, nil
+}
+
+func (m *MockGateway) settle(intentId string) {
+	m.mu.Lock()
+	intent := m.intents[intentId]
+	if intent.Status != StatusPending {
+		m.mu.Unlock()
+		return
+	}
+	intent.Status = StatusAuthorized
+	event := m.event(intent, "payment.authorized")
+	m.mu.Unlock()
+
+	m.deliver(event, 1)
+}
+
+// deliver hands the event to notify and schedules a redelivery when it fails,
+// e.g. because the app hasn't saved the intent id yet.
+func (m *MockGateway) deliver(event Event, attempt int) {
+	if m.notify == nil {
+		return
+	}
+	if err := m.notify(event); err != nil && attempt < mockDeliveryAttempts {
+		time.AfterFunc(mockRetryDelay<<(attempt-1), func() { m.deliver(event, attempt+1) })
+	}
+}
+
+func (m *MockGateway) event(intent *Intent, eventType string) Event {
+	event := Event{
+		Id:        "mock_evt_" + uuid.New().String(),
+		Provider:  m.Name(),
+		Type:      eventType,
+		IntentId:  intent.Id,
+		Reference: intent.Reference,
+		Status:    intent.Status,
+		Amount:    intent.Amount,
+	}
+	event.Payload, _ = json.Marshal(event)
+	return event
+}
+
+func (m *MockGateway) Capture(ctx context.Context, intentId string, amount int) (Intent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	intent, ok := m.intents[intentId]
+	if !ok {
+		return Intent{}, ErrUnknownIntent
+	}
+	if intent.Status != StatusAuthorized || amount > intent.Amount {
+		return *intent, ErrInvalidState
+	}
+
+	intent.Captured = amount
+	intent.Status = StatusPaid
+	return *intent, nil
+}
+
+func (m *MockGateway) Cancel(ctx context.Context, intentId string) (Intent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	intent, ok := m.intents[intentId]
+	if !ok {
+		return Intent{}, ErrUnknownIntent
+	}
+	switch intent.Status {
+	case StatusPending, StatusAuthorized:
+		intent.Status = StatusFailed
+	case StatusFailed:
+	default:
+		return *intent, ErrInvalidState
+	}
+	return *intent, nil
+}
+
+func (m *MockGateway) Refund(ctx context.Context, intentId string, amount int) (Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	intent, ok := m.intents[intentId]
+	if !ok {
+		return Refund{}, ErrUnknownIntent
+	}
+	if intent.Status != StatusPaid {
+		return Refund{}, ErrInvalidState
+	}
+	if amount <= 0 || intent.Refunded+amount > intent.Captured {
+		return Refund{}, ErrRefundTooLarge
+	}
+
+	intent.Refunded += amount
+	if intent.Refunded == intent.Captured {
+		intent.Status = StatusRefunded
+	}
+	return Refund{Id: "mock_re_" + uuid.New().String(), IntentId: intentId, Amount: amount}, nil
+}
+
+// ParseWebhook checks the HMAC signature and decodes an Event serialised as
+// JSON, which is what the mock gateway itself produces.
+func (m *MockGateway) ParseWebhook(header http.Header, body []byte) (Event, error) {
+	if !VerifySignature(m.WebhookSecret, body, header.Get(SignatureHeader)) {
+		return Event{}, ErrBadSignature
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return Event{}, ErrBadWebhook
+	}
+	if event.Id == "" || event.IntentId == "" {
+		return Event{}, ErrBadWebhook
+	}
+	event.Provider = m.Name()
+	event.Payload = body
+	return event, nil
+}
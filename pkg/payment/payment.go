@@ -0,0 +1,90 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+const (
+	StatusPending    = "pending"
+	StatusAuthorized = "authorized"
+	StatusPaid       = "paid"
+	StatusFailed     = "failed"
+	StatusRefunded   = "refunded"
+)
+
+var (
+	ErrUnknownIntent  = errors.New("unknown payment intent")
+	ErrInvalidState   = errors.New("payment intent is not in a state that allows this operation")
+	ErrRefundTooLarge = errors.New("refund exceeds captured amount")
+	ErrBadWebhook     = errors.New("invalid webhook payload")
+	ErrBadSignature   = errors.New("invalid webhook signature")
+)
+
+type IntentRequest struct {
+	Reference string
+	Amount    int
+	Method    string
+}
+
+type Intent struct {
+	Id        string
+	Reference string
+	Amount    int
+	Captured  int
+	Refunded  int
+	Method    string
+	Status    string
+}
+
+type Refund struct {
+	Id       string
+	IntentId string
+	Amount   int
+}
+
+// Event is a provider notification normalised to one of the Status* values.
+type Event struct {
+	Id        string `json:"id"`
+	Provider  string `json:"provider"`
+	Type      string `json:"type"`
+	IntentId  string `json:"intent_id"`
+	Reference string `json:"reference"`
+	Status    string `json:"status"`
+	Amount    int    `json:"amount"`
+	Payload   []byte `json:"-"`
+}
+
+// PaymentProvider is implemented by every gateway the store can charge through.
+// Amounts are in the same unit as transactions.final_amount. When Capture or
+// Cancel fail because the intent already moved on, they still return the intent
+// so its actual status can be recorded.
+type PaymentProvider interface {
+	Name() string
+	CreateIntent(ctx context.Context, req IntentRequest) (Intent, error)
+	Capture(ctx context.Context, intentId string, amount int) (Intent, error)
+	// Cancel voids a pending or authorized intent, it can't be paid afterwards
+	Cancel(ctx context.Context, intentId string) (Intent, error)
+	Refund(ctx context.Context, intentId string, amount int) (Refund, error)
+	ParseWebhook(header http.Header, body []byte) (Event, error)
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]PaymentProvider{}
+)
+
+func Register(provider PaymentProvider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[provider.Name()] = provider
+}
+
+func Get(name string) (PaymentProvider, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	provider, ok := providers[name]
+	return provider, ok
+}
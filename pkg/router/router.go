@@ -0,0 +1,257 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+)
+
+type Middleware func(http.Handler) http.Handler
+
+type contextKey string
+
+const paramsCtxKey contextKey = "routeParams"
+
+var paramTypes = map[string]*regexp.Regexp{
+	"uuid": regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+	"int":  regexp.MustCompile(`^[0-9]+$`),
+	"slug": regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`),
+}
+
+type segment struct {
+	literal  string
+	param    string
+	match    *regexp.Regexp
+	catchAll bool
+}
+
+type route struct {
+	method   string
+	segments []segment
+	handler  http.Handler
+}
+
+// Router dispatches on method and path. Patterns look like /product/{id:uuid};
+// supported parameter types are uuid, int and slug, an untyped {name} matches any
+// single segment and {name...} matches the rest of the path.
+type Router struct {
+	routes     *[]route
+	prefix     string
+	middleware []Middleware
+}
+
+func New() *Router {
+	return &Router{routes: &[]route{}}
+}
+
+// Group returns a router that registers under prefix and wraps every route with
+// the parent's middleware followed by mw.
+func (rt *Router) Group(prefix string, mw ...Middleware) *Router {
+	middleware := make([]Middleware, 0, len(rt.middleware)+len(mw))
+	middleware = append(middleware, rt.middleware...)
+	middleware = append(middleware, mw...)
+
+	return &Router{
+		routes:     rt.routes,
+		prefix:     rt.prefix + prefix,
+		middleware: middleware,
+	}
+}
+
+// Use appends middleware for routes registered on this router afterwards.
+func (rt *Router) Use(mw ...Middleware) {
+	rt.middleware = append(rt.middleware, mw...)
+}
+
+func (rt *Router) Handle(method string, pattern string, handler http.Handler, mw ...Middleware) {
+	chain := append(append([]Middleware{}, rt.middleware...), mw...)
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](handler)
+	}
+
+	*rt.routes = append(*rt.routes, route{
+		method:   method,
+		segments: parsePattern(rt.prefix + pattern),
+		handler:  handler,
+	})
+}
+
+func (rt *Router) HandleFunc(method string, pattern string, handler http.HandlerFunc, mw ...Middleware) {
+	rt.Handle(method, pattern, handler, mw...)
+}
+
+func (rt *Router) Get(pattern string, handler http.HandlerFunc, mw ...Middleware) {
+	rt.Handle(http.MethodGet, pattern, handler, mw...)
+}
+
+func (rt *Router) Post(pattern string, handler http.HandlerFunc, mw ...Middleware) {
+	rt.Handle(http.MethodPost, pattern, handler, mw...)
+}
+
+func (rt *Router) Put(pattern string, handler http.HandlerFunc, mw ...Middleware) {
+	rt.Handle(http.MethodPut, pattern, handler, mw...)
+}
+
+func (rt *Router) Patch(pattern string, handler http.HandlerFunc, mw ...Middleware) {
+	rt.Handle(http.MethodPatch, pattern, handler, mw...)
+}
+
+func (rt *Router) Delete(pattern string, handler http.HandlerFunc, mw ...Middleware) {
+	rt.Handle(http.MethodDelete, pattern, handler, mw...)
+}
+
+// Param returns the path parameter captured for name, or "" when the route has none.
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsCtxKey).(map[string]string)
+	return params[name]
+}
+
+func parsePattern(pattern string) []segment {
+	parts := splitPath(pattern)
+	segments := make([]segment, 0, len(parts))
+
+	for _, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			segments = append(segments, segment{literal: part})
+			continue
+		}
+
+		name := part[1 : len(part)-1]
+		if strings.HasSuffix(name, "...") {
+			segments = append(segments, segment{param: strings.TrimSuffix(name, "..."), catchAll: true})
+			continue
+		}
+
+		seg := segment{param: name}
+		if i := strings.Index(name, ":"); i >= 0 {
+			re, ok := paramTypes[name[i+1:]]
+			if !ok {
+				panic("router: unknown parameter type in " + pattern)
+			}
+			seg.param = name[:i]
+			seg.match = re
+		}
+		segments = append(segments, seg)
+	}
+
+	return segments
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// matchPath returns the captured params and a specificity score, literals
+// beating typed params beating plain params beating catch-alls.
+func (r *route) matchPath(parts []string) (map[string]string, []int, bool) {
+	params := map[string]string{}
+	score := make([]int, 0, len(r.segments))
+
+	for i, seg := range r.segments {
+		if seg.catchAll {
+			params[seg.param] = strings.Join(parts[i:], "/")
+			return params, append(score, 0), len(parts) > i
+		}
+		if i >= len(parts) {
+			return nil, nil, false
+		}
+
+		switch {
+		case seg.param == "":
+			if seg.literal != parts[i] {
+				return nil, nil, false
+			}
+			score = append(score, 3)
+		case seg.match != nil:
+			if !seg.match.MatchString(parts[i]) {
+				return nil, nil, false
+			}
+			params[seg.param] = parts[i]
+			score = append(score, 2)
+		default:
+			params[seg.param] = parts[i]
+			score = append(score, 1)
+		}
+	}
+
+	return params, score, len(parts) == len(r.segments)
+}
+
+func moreSpecific(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] > b[i]
+		}
+	}
+	return len(a) > len(b)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(r.URL.Path)
+
+	var best *route
+	var bestParams map[string]string
+	var bestScore []int
+	allowed := map[string]bool{}
+
+	for i := range *rt.routes {
+		candidate := &(*rt.routes)[i]
+		params, score, ok := candidate.matchPath(parts)
+		if !ok {
+			continue
+		}
+		allowed[candidate.method] = true
+
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		if candidate.method != method {
+			continue
+		}
+		if best == nil || moreSpecific(score, bestScore) {
+			best, bestParams, bestScore = candidate, params, score
+		}
+	}
+
+	if best != nil {
+		ctx := context.WithValue(r.Context(), paramsCtxKey, bestParams)
+		best.handler.ServeHTTP(w, r.WithContext(ctx))
+		return
+	}
+
+	if len(allowed) == 0 {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	methods := make([]string, 0, len(allowed))
+	for method := range allowed {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	httperrors.HandleError(w, httperrors.Response{
+		Status: "failed",
+		Data:   nil,
+		Errors: &httperrors.Errors{Code: code, Message: message},
+	}, code)
+}
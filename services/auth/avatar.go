@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"mime/multipart"
+	"strings"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/media"
+	"github.com/dikletscode/isyana-store/pkg/storage"
+	"github.com/google/uuid"
+)
+
+const avatarPrefix = "avatars/"
+
+// blobStore holds uploaded avatars, it is set when the routes are mounted.
+var blobStore storage.BlobStore = storage.NewLocalStore("")
+
+// avatarURL turns a stored avatar key into the URL it is served from. Photos
+// set before uploads existed hold a URL already and are returned as they are.
+func avatarURL(photo *string) *string {
+	if photo == nil || !strings.HasPrefix(*photo, avatarPrefix) {
+		return photo
+	}
+	url := media.URL(*photo)
+	return &url
+}
+
+// replaceAvatar points the user at new avatar keys, nil for none, and removes
+// the files of the previous avatar.
+func replaceAvatar(ctx context.Context, userId string, key *string, thumbKey *string) (*user, error) {
+	var account user
+	var oldKey, oldThumbKey *string
+	query := `UPDATE users u SET photo = $2, photo_thumbnail = $3, updated_at = now()
+	FROM (SELECT id, photo, photo_thumbnail FROM users WHERE id = $1 FOR UPDATE) old
+	WHERE u.id = old.id
+	RETURNING u.id::text, u.username, u.photo, u.photo_thumbnail, u.created_at, u.updated_at, old.photo, old.photo_thumbnail`
+	err := db.PG.QueryRow(ctx, query, userId, key, thumbKey).Scan(&account.Id, &account.Username, &account.Photo, &account.PhotoThumbnail, &account.CreatedAt, &account.UpdatedAt, &oldKey, &oldThumbKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, old := range []*string{oldKey, oldThumbKey} {
+		if old == nil || !strings.HasPrefix(*old, avatarPrefix) {
+			continue
+		}
+		if err := blobStore.Delete(ctx, *old); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	account.Photo, account.PhotoThumbnail = avatarURL(account.Photo), avatarURL(account.PhotoThumbnail)
+	return &account, nil
+}
+
+func uploadAvatar(userId string, file *multipart.FileHeader) response {
+	img, err := media.ProcessFile(file)
+	if code := media.StatusCode(err); code != 0 {
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    code,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	ctx := context.Background()
+	var key, thumbKey string
+	if err == nil {
+		key, thumbKey, err = media.Save(ctx, blobStore, avatarPrefix+userId, uuid.New().String(), img)
+	}
+	var account *user
+	if err == nil {
+		account, err = replaceAvatar(ctx, userId, &key, &thumbKey)
+		if err != nil {
+			blobStore.Delete(ctx, key)
+			blobStore.Delete(ctx, thumbKey)
+		}
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	return response{
+		Status: "success",
+		Data:   account,
+		Errors: nil,
+	}
+}
+
+func deleteAvatar(userId string) response {
+	account, err := replaceAvatar(context.Background(), userId, nil, nil)
+	if err != nil {
+		log.Println(err.Error())
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	return response{
+		Status: "success",
+		Data:   account,
+		Errors: nil,
+	}
+}
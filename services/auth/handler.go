@@ -4,12 +4,13 @@ import (
 	"context"
 	"errors"
 	"log"
-	"os"
 	"time"
 
 	"github.com/dikletscode/isyana-store/db"
 	"github.com/dikletscode/isyana-store/pkg/httperrors"
 	"github.com/dikletscode/isyana-store/pkg/validator"
+	"github.com/dikletscode/isyana-store/services/order"
+	"github.com/dikletscode/isyana-store/services/review"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -24,7 +25,9 @@ type response struct {
 }
 
 type token struct {
-	Access_token string `json:"access_token"`
+	Access_token  string `json:"access_token"`
+	Refresh_token string `json:"refresh_token"`
+	Expires_in    int    `json:"expires_in"`
 }
 type loginResponse struct {
 	Status string             `json:"status"`
@@ -107,7 +110,9 @@ func register(newUser userLogin) response {
 
 }
 
-func login(userRequest userLogin) loginResponse {
+// login starts a session. guestCartId is the X-Cart-Id the visitor shopped with
+// before logging in, its items are moved into the user's cart.
+func login(userRequest userLogin, guestCartId string) loginResponse {
 
 	if validator.IsContainSymbol(userRequest.Username) || validator.IsNotValidPassword(userRequest.Password) {
 		// log.Println(err.Error())
@@ -121,12 +126,13 @@ func login(userRequest userLogin) loginResponse {
 		}
 	}
 
-	query := "SELECT id, username, password FROM users WHERE username = $1"
+	query := "SELECT id, username, password, user_type FROM users WHERE username = $1"
 
 	var id string
 	var username string
 	var password string
-	err := db.PG.QueryRow(context.Background(), query, userRequest.Username).Scan(&id, &username, &password)
+	var userType string
+	err := db.PG.QueryRow(context.Background(), query, userRequest.Username).Scan(&id, &username, &password, &userType)
 
 	if err != nil {
 		log.Println(err.Error())
@@ -174,16 +180,7 @@ func login(userRequest userLogin) loginResponse {
 			},
 		}
 	}
-	claims := jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		Issuer:    "test",
-		ID:        id,
-	}
-	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	mySigningKey := []byte(os.Getenv("SECRET_TOKEN"))
-
-	signed, err := tok.SignedString(mySigningKey)
+	tok, err := startSession(context.Background(), id, userType)
 
 	if err != nil {
 		log.Println(err.Error())
@@ -197,9 +194,16 @@ func login(userRequest userLogin) loginResponse {
 		}
 	}
 
+	if guestCartId != "" {
+		// a cart that can't be merged must not block the login
+		if err = order.MergeGuestCart(context.Background(), id, guestCartId); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
 	return loginResponse{
 		Status: "success",
-		Data:   &token{Access_token: signed},
+		Data:   tok,
 		Errors: nil,
 	}
 
@@ -207,7 +211,7 @@ func login(userRequest userLogin) loginResponse {
 func getProfile(claims jwt.MapClaims) response {
 
 	query := `SELECT 
-	id, full_name, username, photo, shipping_address, user_type, created_at, updated_at  
+	id, full_name, username, photo, photo_thumbnail, shipping_address, user_type, created_at, updated_at  
 	FROM users where id = $1`
 
 	// args := pgx.NamedArgs{
@@ -228,6 +232,12 @@ func getProfile(claims jwt.MapClaims) response {
 		}
 	}
 	account, err := pgx.CollectOneRow(rows, pgx.RowToStructByPos[user])
+	account.Photo, account.PhotoThumbnail = avatarURL(account.Photo), avatarURL(account.PhotoThumbnail)
+	if err == nil && account.UserType != nil && *account.UserType == "S" {
+		var reputation review.Reputation
+		reputation, err = review.SellerReputation(context.Background(), account.Id)
+		account.Reputation = &reputation
+	}
 
 	if err != nil {
 		log.Println(err.Error())
@@ -248,3 +258,37 @@ func getProfile(claims jwt.MapClaims) response {
 	}
 
 }
+
+// upgradeToSeller turns a buyer account into a seller account.
+// The new role shows up in the access token after the next /token/refresh.
+func upgradeToSeller(claims jwt.MapClaims) response {
+
+	query := `UPDATE users SET user_type = 'S', updated_at = now()
+	WHERE id = $1 AND user_type = 'B'`
+
+	comTag, err := db.PG.Exec(context.Background(), query, claims["jti"])
+	if err != nil {
+		log.Println(err.Error())
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	if comTag.RowsAffected() == 0 {
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    409,
+				Message: "Account is not a buyer account",
+			},
+		}
+	}
+
+	return getProfile(claims)
+}
@@ -1,6 +1,10 @@
 package auth
 
-import "time"
+import (
+	"time"
+
+	"github.com/dikletscode/isyana-store/services/review"
+)
 
 type userLogin struct {
 	Username string `json:"username"`
@@ -12,8 +16,11 @@ type user struct {
 	FullName        *string   `json:"full_name,omitempty"`
 	Username        string    `json:"username"`
 	Photo           *string   `json:"photo,omitempty"`
+	PhotoThumbnail  *string   `json:"photo_thumbnail,omitempty"`
 	ShippingAddress *string   `json:"shipping_address,omitempty"`
 	UserType        *string   `json:"user_type,omitempty"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
+	// Reputation is only filled in for sellers
+	Reputation *review.Reputation `json:"reputation,omitempty" db:"-"`
 }
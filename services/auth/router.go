@@ -7,15 +7,16 @@ import (
 
 	"github.com/dikletscode/isyana-store/middleware"
 	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/media"
+	"github.com/dikletscode/isyana-store/pkg/router"
+	"github.com/dikletscode/isyana-store/pkg/storage"
+	"github.com/dikletscode/isyana-store/services/order"
 )
 
-func AuthRouters() {
+func AuthRouters(rt *router.Router, store storage.BlobStore) {
+	blobStore = store
 
-	http.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
+	rt.Post("/register", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		decoder := json.NewDecoder(r.Body)
 
@@ -47,26 +48,26 @@ func AuthRouters() {
 		}
 
 	})
-	http.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
+	rt.Post("/login", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		decoder := json.NewDecoder(r.Body)
 		var user userLogin
 		err := decoder.Decode(&user)
-		resp := login(user)
+		// a partly decoded body must not open a session, logging in merges and
+		// deletes the guest cart
+		var resp loginResponse
 		if err != nil {
 			log.Println(err.Error())
 			resp = loginResponse{
 				Status: "failed",
 				Data:   nil,
 				Errors: &httperrors.Errors{
-					Code:    500,
-					Message: httperrors.C500,
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
 				},
 			}
+		} else {
+			resp = login(user, r.Header.Get(order.CartHeader))
 		}
 
 		if resp.Status == "success" {
@@ -81,12 +82,59 @@ func AuthRouters() {
 
 	})
 
-	http.Handle("/profile", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	rt.Post("/token/refresh", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		decoder := json.NewDecoder(r.Body)
+		var req refreshRequest
+		err := decoder.Decode(&req)
+		// a token is only rotated for a clean body, a partly decoded one would
+		// burn the refresh token and lose the new pair with the 400
+		var resp loginResponse
+		if err != nil {
+			log.Println(err.Error())
+			resp = loginResponse{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
+			}
+		} else {
+			resp = refreshToken(req)
+		}
 
-		if r.Method != http.MethodGet {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
 		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+
+	})
+
+	rt.Post("/logout", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		response := logout(claims)
+
+		if response.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(response.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(response)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, middleware.AuthMiddleware)
+
+	rt.Get("/profile", func(w http.ResponseWriter, r *http.Request) {
+
 		claims := middleware.UserFromContext(r.Context())
 
 		response := getProfile(claims)
@@ -100,8 +148,85 @@ func AuthRouters() {
 		if err != nil {
 			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
 		}
-	}), nil))
-	http.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+	}, middleware.AuthMiddleware)
+	rt.Post("/profile/seller", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		response := upgradeToSeller(claims)
+
+		if response.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(response.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(response)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, middleware.AuthMiddleware, middleware.RequireRole(middleware.RoleBuyer))
+	rt.Put("/profile/avatar", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		var resp response
+		files, err := media.Files(w, r, "avatar", 1)
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
+		}
+		if code := media.StatusCode(err); code != 0 {
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    code,
+					Message: err.Error(),
+				},
+			}
+		} else if err != nil {
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: expected a multipart form with an avatar",
+				},
+			}
+		} else {
+			resp = uploadAvatar(jwtUserID, files[0])
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, middleware.AuthMiddleware)
+	rt.Delete("/profile/avatar", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		response := deleteAvatar(jwtUserID)
+
+		if response.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(response.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(response)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, middleware.AuthMiddleware)
+	rt.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 		type Test struct {
 			name string
 			Body string
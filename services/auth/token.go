@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/middleware"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+type accessClaims struct {
+	SessionId string   `json:"sid"`
+	Roles     []string `json:"roles"`
+	jwt.RegisteredClaims
+}
+
+type refreshRequest struct {
+	Refresh_token string `json:"refresh_token"`
+}
+
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func signAccessToken(userId string, userType string, sessionId string) (string, error) {
+	claims := accessClaims{
+		SessionId: sessionId,
+		Roles:     middleware.RolesForUserType(userType),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "test",
+			ID:        userId,
+		},
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return tok.SignedString([]byte(os.Getenv("SECRET_TOKEN")))
+}
+
+// issueTokens stores a new refresh token in the given family and signs the
+// matching access token. Only the hash of the refresh token is persisted.
+func issueTokens(ctx context.Context, q pgx.Tx, userId string, userType string, familyId string) (*token, error) {
+	refresh, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at)
+	VALUES (@id, @userId, @familyId, @tokenHash, @expiresAt)`
+	args := pgx.NamedArgs{
+		"id":        uuid.New(),
+		"userId":    userId,
+		"familyId":  familyId,
+		"tokenHash": hashToken(refresh),
+		"expiresAt": time.Now().Add(refreshTokenTTL),
+	}
+	if _, err = q.Exec(ctx, query, args); err != nil {
+		return nil, err
+	}
+
+	access, err := signAccessToken(userId, userType, familyId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token{
+		Access_token:  access,
+		Refresh_token: refresh,
+		Expires_in:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// startSession opens a new token family for a freshly authenticated user.
+func startSession(ctx context.Context, userId string, userType string) (*token, error) {
+	var tok *token
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		var err error
+		tok, err = issueTokens(ctx, tx, userId, userType, uuid.New().String())
+		return err
+	})
+	return tok, err
+}
+
+// rotateRefreshToken exchanges a refresh token for a new pair. A token that was
+// already exchanged once is treated as stolen and its whole family is revoked.
+func rotateRefreshToken(ctx context.Context, raw string) (*token, error) {
+	var tok *token
+	var reused bool
+
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		var id, userId, userType, familyId string
+		var expiresAt time.Time
+		var usedAt, revokedAt *time.Time
+
+		// user_type is re-read on every refresh so role changes apply to the next access token
+		query := `SELECT rt.id, rt.user_id, u.user_type, rt.family_id, rt.expires_at, rt.used_at, rt.revoked_at
+		FROM refresh_tokens rt JOIN users u ON u.id = rt.user_id
+		WHERE rt.token_hash = $1 FOR UPDATE OF rt`
+		err := tx.QueryRow(ctx, query, hashToken(raw)).Scan(&id, &userId, &userType, &familyId, &expiresAt, &usedAt, &revokedAt)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return errInvalidRefreshToken
+			}
+			return err
+		}
+
+		if revokedAt != nil || time.Now().After(expiresAt) {
+			return errInvalidRefreshToken
+		}
+		if usedAt != nil {
+			reused = true
+			return revokeFamily(ctx, tx, familyId)
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = now() WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		tok, err = issueTokens(ctx, tx, userId, userType, familyId)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		log.Println("refresh token reuse detected, session revoked")
+		return nil, errInvalidRefreshToken
+	}
+	return tok, nil
+}
+
+func revokeFamily(ctx context.Context, tx pgx.Tx, familyId string) error {
+	_, err := tx.Exec(ctx, `UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL`, familyId)
+	return err
+}
+
+func refreshToken(req refreshRequest) loginResponse {
+	if len(req.Refresh_token) == 0 {
+		return loginResponse{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    400,
+				Message: "Bad Request: Missing refresh token",
+			},
+		}
+	}
+
+	tok, err := rotateRefreshToken(context.Background(), req.Refresh_token)
+	if err != nil {
+		if err == errInvalidRefreshToken {
+			return loginResponse{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    401,
+					Message: "Unauthorize",
+				},
+			}
+		}
+		log.Println(err.Error())
+		return loginResponse{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	return loginResponse{
+		Status: "success",
+		Data:   tok,
+		Errors: nil,
+	}
+}
+
+func logout(claims jwt.MapClaims) response {
+	sessionId, ok := claims["sid"].(string)
+	if !ok {
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    401,
+				Message: "Unauthorize",
+			},
+		}
+	}
+
+	err := pgx.BeginFunc(context.Background(), db.PG, func(tx pgx.Tx) error {
+		return revokeFamily(context.Background(), tx, sessionId)
+	})
+	if err != nil {
+		log.Println(err.Error())
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	return response{
+		Status: "success",
+		Data:   nil,
+		Errors: nil,
+	}
+}
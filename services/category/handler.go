@@ -0,0 +1,318 @@
+package category
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	errCategoryNotFound = errors.New("Category not found")
+	errParentNotFound   = errors.New("Bad Request: Unknown parent category")
+	errParentCycle      = errors.New("Bad Request: a category can't be nested under itself or its descendants")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+type category struct {
+	Id         string    `json:"id"`
+	Name       string    `json:"name"`
+	Slug       string    `json:"slug"`
+	Components string    `json:"components"`
+	ParentId   *string   `json:"parent_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// categoryDetail is a category with its ancestors, root first, and its direct
+// children.
+type categoryDetail struct {
+	category
+	Path     []category `json:"path"`
+	Children []category `json:"children"`
+}
+
+type response struct {
+	Status string             `json:"status"`
+	Data   *category          `json:"data"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+type responseArr struct {
+	Status string             `json:"status"`
+	Data   []category         `json:"data"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+type responseDetail struct {
+	Status string             `json:"status"`
+	Data   *categoryDetail    `json:"data"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+const categoryColumns = `id::text, name, slug, components, parent_id::text, created_at, updated_at`
+
+// slugify lowercases name and joins its words with dashes, "Men's Shoes"
+// becomes "men-s-shoes".
+func slugify(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r > unicode.MaxASCII || (!unicode.IsLetter(r) && !unicode.IsDigit(r))
+	})
+	return strings.Join(words, "-")
+}
+
+func isValidCategory(c *category) bool {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Slug == "" {
+		c.Slug = slugify(c.Name)
+	}
+	if len(c.Name) < 2 || len(c.Name) > 50 || len(c.Components) > 100 {
+		return false
+	}
+	if len(c.Slug) > 60 || !slugPattern.MatchString(c.Slug) {
+		return false
+	}
+	if c.ParentId != nil {
+		if _, err := uuid.Parse(*c.ParentId); err != nil || *c.ParentId == c.Id {
+			return false
+		}
+	}
+	return true
+}
+
+func failed(code int, message string) *httperrors.Errors {
+	return &httperrors.Errors{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// writeError maps the errors of saving a category to a response.
+func writeError(err error) response {
+	var pgErr *pgconn.PgError
+	var code int
+	var message string
+	switch {
+	case err == errCategoryNotFound:
+		code, message = 404, err.Error()
+	case err == errParentNotFound || err == errParentCycle:
+		code, message = 400, err.Error()
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		code, message = 409, "Slug already exists. Please use a different slug."
+	case errors.As(err, &pgErr) && pgErr.Code == "23503":
+		code, message = 400, errParentNotFound.Error()
+	default:
+		log.Println(err.Error())
+		code, message = 500, httperrors.C500
+	}
+	return response{
+		Status: "failed",
+		Data:   nil,
+		Errors: failed(code, message),
+	}
+}
+
+func getCategories() responseArr {
+	rows, err := db.PG.Query(context.Background(), `SELECT `+categoryColumns+` FROM categories ORDER BY name, id`)
+	categories := []category{}
+	if err == nil {
+		categories, err = pgx.CollectRows(rows, pgx.RowToStructByPos[category])
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return responseArr{
+			Status: "failed",
+			Data:   nil,
+			Errors: failed(500, httperrors.C500),
+		}
+	}
+
+	return responseArr{
+		Status: "success",
+		Data:   categories,
+		Errors: nil,
+	}
+}
+
+func getCategoryById(id string) responseDetail {
+	ctx := context.Background()
+	var detail categoryDetail
+
+	rows, err := db.PG.Query(ctx, `SELECT `+categoryColumns+` FROM categories WHERE id = $1`, id)
+	if err == nil {
+		detail.category, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[category])
+	}
+	if err == pgx.ErrNoRows {
+		return responseDetail{
+			Status: "failed",
+			Data:   nil,
+			Errors: failed(404, errCategoryNotFound.Error()),
+		}
+	}
+
+	if err == nil {
+		rows, err = db.PG.Query(ctx, `WITH RECURSIVE path AS (
+			SELECT c.*, 1 AS depth FROM categories c
+			WHERE c.id = (SELECT parent_id FROM categories WHERE id = $1)
+			UNION ALL
+			SELECT c.*, path.depth + 1 FROM categories c JOIN path ON c.id = path.parent_id
+		)
+		SELECT `+categoryColumns+` FROM path ORDER BY depth DESC`, id)
+	}
+	if err == nil {
+		detail.Path, err = pgx.CollectRows(rows, pgx.RowToStructByPos[category])
+	}
+	if err == nil {
+		rows, err = db.PG.Query(ctx, `SELECT `+categoryColumns+` FROM categories WHERE parent_id = $1 ORDER BY name, id`, id)
+	}
+	if err == nil {
+		detail.Children, err = pgx.CollectRows(rows, pgx.RowToStructByPos[category])
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		return responseDetail{
+			Status: "failed",
+			Data:   nil,
+			Errors: failed(500, httperrors.C500),
+		}
+	}
+
+	return responseDetail{
+		Status: "success",
+		Data:   &detail,
+		Errors: nil,
+	}
+}
+
+func postCategory(c category) response {
+	c.Id = uuid.New().String()
+	if !isValidCategory(&c) {
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: failed(400, "Bad Request: Invalid input data"),
+		}
+	}
+
+	query := `INSERT INTO categories (id, name, slug, components, parent_id)
+	VALUES (@id, @name, @slug, @components, @parentId)
+	RETURNING created_at, updated_at`
+	args := pgx.NamedArgs{
+		"id":         c.Id,
+		"name":       c.Name,
+		"slug":       c.Slug,
+		"components": c.Components,
+		"parentId":   c.ParentId,
+	}
+	if err := db.PG.QueryRow(context.Background(), query, args).Scan(&c.CreatedAt, &c.UpdatedAt); err != nil {
+		return writeError(err)
+	}
+
+	return response{
+		Status: "success",
+		Data:   &c,
+		Errors: nil,
+	}
+}
+
+// isDescendant reports whether candidate is id or sits somewhere below it.
+func isDescendant(ctx context.Context, tx pgx.Tx, id string, candidate string) (bool, error) {
+	var found bool
+	err := tx.QueryRow(ctx, `WITH RECURSIVE up AS (
+		SELECT id, parent_id FROM categories WHERE id = $2
+		UNION ALL
+		SELECT c.id, c.parent_id FROM categories c JOIN up ON c.id = up.parent_id
+	)
+	SELECT EXISTS (SELECT 1 FROM up WHERE id = $1)`, id, candidate).Scan(&found)
+	return found, err
+}
+
+func putCategory(c category) response {
+	if !isValidCategory(&c) {
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: failed(400, "Bad Request: Invalid input data"),
+		}
+	}
+
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		if c.ParentId != nil {
+			// moves are serialized so two of them can't close a loop together
+			if _, err := tx.Exec(ctx, `LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+				return err
+			}
+			cycle, err := isDescendant(ctx, tx, c.Id, *c.ParentId)
+			if err != nil {
+				return err
+			}
+			if cycle {
+				return errParentCycle
+			}
+		}
+
+		query := `UPDATE categories SET name = @name, slug = @slug, components = @components,
+		parent_id = @parentId, updated_at = now()
+		WHERE id = @id
+		RETURNING created_at, updated_at`
+		args := pgx.NamedArgs{
+			"id":         c.Id,
+			"name":       c.Name,
+			"slug":       c.Slug,
+			"components": c.Components,
+			"parentId":   c.ParentId,
+		}
+		err := tx.QueryRow(ctx, query, args).Scan(&c.CreatedAt, &c.UpdatedAt)
+		if err == pgx.ErrNoRows {
+			return errCategoryNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return writeError(err)
+	}
+
+	return response{
+		Status: "success",
+		Data:   &c,
+		Errors: nil,
+	}
+}
+
+// deleteCategory removes a category without children, its products become
+// uncategorized.
+func deleteCategory(id string) response {
+	comTag, err := db.PG.Exec(context.Background(), `DELETE FROM categories WHERE id = $1`, id)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: failed(409, "Category still has child categories, move or delete them first"),
+		}
+	}
+	if err == nil && comTag.RowsAffected() == 0 {
+		err = errCategoryNotFound
+	}
+	if err != nil {
+		return writeError(err)
+	}
+
+	return response{
+		Status: "success",
+		Data:   nil,
+		Errors: nil,
+	}
+}
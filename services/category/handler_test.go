@@ -0,0 +1,27 @@
+package category
+
+import "testing"
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Home & Kitchen", "home-kitchen"},
+		{"  Men's  Shoes ", "men-s-shoes"},
+		{"USB-C Cables", "usb-c-cables"},
+		{"4K TVs", "4k-tvs"},
+		{"Café Crème", "caf-cr-me"},
+		{"---", ""},
+	}
+
+	for _, tt := range tests {
+		got := slugify(tt.name)
+		if got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if got != "" && !slugPattern.MatchString(got) {
+			t.Errorf("slugify(%q) = %q, which is not a valid slug", tt.name, got)
+		}
+	}
+}
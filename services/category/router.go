@@ -0,0 +1,115 @@
+package category
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dikletscode/isyana-store/middleware"
+	"github.com/dikletscode/isyana-store/pkg/router"
+)
+
+func CategoryRouter(rt *router.Router) {
+	categories := rt.Group("/category")
+	adminOnly := []router.Middleware{middleware.AuthMiddleware, middleware.RequireRole(middleware.RoleAdmin)}
+
+	categories.Get("", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		resp := getCategories()
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
+
+	categories.Get("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		resp := getCategoryById(router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
+
+	categories.Post("", func(w http.ResponseWriter, r *http.Request) {
+
+		var incoming category
+		var resp response
+		err := json.NewDecoder(r.Body).Decode(&incoming)
+		if err != nil {
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: failed(400, "Bad Request: Invalid input data"),
+			}
+		} else {
+			resp = postCategory(incoming)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, adminOnly...)
+
+	categories.Put("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+
+		var incoming category
+		var resp response
+		err := json.NewDecoder(r.Body).Decode(&incoming)
+		incoming.Id = router.Param(r, "id")
+		if err != nil {
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: failed(400, "Bad Request: Invalid input data"),
+			}
+		} else {
+			resp = putCategory(incoming)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, adminOnly...)
+
+	categories.Delete("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+
+		resp := deleteCategory(router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, adminOnly...)
+
+}
@@ -0,0 +1,473 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxCartLines is how many different variants a cart may hold.
+const MaxCartLines = 20
+
+// CartHeader carries the guest cart id for visitors who are not logged in.
+const CartHeader = "X-Cart-Id"
+
+var (
+	errCartFull          = errors.New("Cart Limit Exceeded: Please remove items to proceed.")
+	errCartLineNotFound  = errors.New("Cart item not found")
+	errGuestCartNotFound = errors.New("Cart not found")
+	errProductNotFound   = errors.New("Product not found")
+	errVariantNotFound   = errors.New("Variant not found")
+	errVariantRequired   = errors.New("the product has several variants, pick one with variant_id")
+	errInsufficientStock = errors.New("Insufficient stock for items ")
+)
+
+// cartOwner is either a logged in user or a guest cart, never both. A guest
+// without a cart yet has neither, the first add creates one.
+type cartOwner struct {
+	UserId string
+	CartId string
+}
+
+func (o cartOwner) isGuest() bool {
+	return o.UserId == ""
+}
+
+type cartItem struct {
+	OrderId   *string           `json:"order_id,omitempty"`
+	ProductId string            `json:"product_id"`
+	VariantId string            `json:"variant_id"`
+	Name      string            `json:"name"`
+	Options   map[string]string `json:"options"`
+	Note      *string           `json:"note"`
+	Quantity  int               `json:"quantity"`
+	UnitPrice int               `json:"unit_price"`
+	Subtotal  int               `json:"subtotal"`
+	Available int               `json:"available"`
+}
+
+type cart struct {
+	CartId    *string    `json:"cart_id,omitempty"`
+	Items     []cartItem `json:"items"`
+	ItemCount int        `json:"item_count"`
+	Total     int        `json:"total"`
+}
+
+// cartItemRequest names the variant to buy. The product id alone is enough for
+// a product with a single variant, the variant id alone for any variant.
+type cartItemRequest struct {
+	ProductId string  `json:"product_id"`
+	VariantId string  `json:"variant_id"`
+	Quantity  int     `json:"quantity"`
+	Note      *string `json:"note"`
+}
+
+type responseCart struct {
+	Status string             `json:"status"`
+	Data   *cart              `json:"data"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+// lockCart serialises writes to one cart so the line limit can't be raced.
+// Writing to a guest cart also keeps it from expiring, see GuestCartTTL.
+func lockCart(ctx context.Context, tx pgx.Tx, owner cartOwner) error {
+	key := owner.UserId
+	if owner.isGuest() {
+		tag, err := tx.Exec(ctx, `UPDATE guest_carts SET updated_at = now() WHERE id = $1`, owner.CartId)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return errGuestCartNotFound
+		}
+		key = owner.CartId
+	}
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('cart:' || $1))`, key)
+	return err
+}
+
+// loadCart prices every line at the current price of its variant.
+func loadCart(ctx context.Context, owner cartOwner) (*cart, error) {
+	c := &cart{Items: []cartItem{}}
+	if owner.isGuest() && owner.CartId == "" {
+		return c, nil
+	}
+
+	var rows pgx.Rows
+	var err error
+	if owner.isGuest() {
+		c.CartId = &owner.CartId
+		rows, err = db.PG.Query(ctx, `SELECT NULL::text, p.id::text, v.id::text, p.name, v.options, g.note, g.quantity, COALESCE(v.price, p.price)::INTEGER, a.available
+		FROM guest_cart_items g JOIN product_variants v ON v.id = g.variant_id
+		JOIN products p ON p.id = v.product_id
+		JOIN variant_availability a ON a.variant_id = v.id
+		WHERE g.cart_id = $1 AND p.deleted_at IS NULL AND v.deleted_at IS NULL
+		ORDER BY g.created_at, v.id`, owner.CartId)
+	} else {
+		rows, err = db.PG.Query(ctx, `SELECT o.id::text, p.id::text, v.id::text, p.name, v.options, o.note, o.quantity, COALESCE(v.price, p.price)::INTEGER, a.available
+		FROM orders o JOIN product_variants v ON v.id = o.variant_id
+		JOIN products p ON p.id = v.product_id
+		JOIN variant_availability a ON a.variant_id = v.id
+		WHERE o.user_id = $1 AND o.purchase_status = $2 AND p.deleted_at IS NULL AND v.deleted_at IS NULL
+		ORDER BY o.created_at, o.id`, owner.UserId, StatusInCart)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item cartItem
+		err = rows.Scan(&item.OrderId, &item.ProductId, &item.VariantId, &item.Name, &item.Options, &item.Note, &item.Quantity, &item.UnitPrice, &item.Available)
+		if err != nil {
+			return nil, err
+		}
+		item.Subtotal = item.Quantity * item.UnitPrice
+		c.Items = append(c.Items, item)
+		c.ItemCount += item.Quantity
+		c.Total += item.Subtotal
+	}
+	return c, rows.Err()
+}
+
+// rowQuerier is satisfied by both the pool and a transaction.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// variantStock resolves the variant a line buys, see cartItemRequest, and
+// returns it with its available stock, stock minus what checkouts hold.
+func variantStock(ctx context.Context, q rowQuerier, productId string, variantId string) (string, int, error) {
+	if productId == "" && variantId == "" {
+		return "", 0, errProductNotFound
+	}
+
+	var stock, variants int
+	query := `SELECT v.id::text, a.available, count(*) OVER ()::INTEGER
+	FROM products p JOIN product_variants v ON v.product_id = p.id
+	JOIN variant_availability a ON a.variant_id = v.id
+	WHERE p.deleted_at IS NULL AND v.deleted_at IS NULL
+	AND ($1 = '' OR p.id::text = $1) AND ($2 = '' OR v.id::text = $2)
+	LIMIT 1`
+	err := q.QueryRow(ctx, query, productId, variantId).Scan(&variantId, &stock, &variants)
+	switch {
+	case err == pgx.ErrNoRows && variantId != "":
+		return "", 0, errVariantNotFound
+	case err == pgx.ErrNoRows:
+		return "", 0, errProductNotFound
+	case err == nil && variants > 1:
+		return "", 0, errVariantRequired
+	}
+	return variantId, stock, err
+}
+
+// addCartItem adds quantity to the line of the variant, opening the line if
+// needed. It returns the owner, which gains a CartId when a guest cart is created.
+func addCartItem(ctx context.Context, owner cartOwner, req cartItemRequest) (cartOwner, error) {
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		if owner.isGuest() && owner.CartId == "" {
+			owner.CartId = uuid.New().String()
+			if _, err := tx.Exec(ctx, `INSERT INTO guest_carts (id) VALUES ($1)`, owner.CartId); err != nil {
+				return err
+			}
+		}
+		if err := lockCart(ctx, tx, owner); err != nil {
+			return err
+		}
+
+		variantId, stock, err := variantStock(ctx, tx, req.ProductId, req.VariantId)
+		if err != nil {
+			return err
+		}
+
+		var current, lines int
+		if owner.isGuest() {
+			err = tx.QueryRow(ctx, `SELECT COALESCE(SUM(quantity) FILTER (WHERE variant_id = $2), 0), count(*)
+			FROM guest_cart_items WHERE cart_id = $1`, owner.CartId, variantId).Scan(&current, &lines)
+		} else {
+			err = tx.QueryRow(ctx, `SELECT COALESCE(SUM(quantity) FILTER (WHERE variant_id = $2), 0), count(*)
+			FROM orders WHERE user_id = $1 AND purchase_status = $3`, owner.UserId, variantId, StatusInCart).Scan(&current, &lines)
+		}
+		if err != nil {
+			return err
+		}
+
+		if current == 0 && lines >= MaxCartLines {
+			return errCartFull
+		}
+		if current+req.Quantity > stock {
+			return errInsufficientStock
+		}
+
+		args := pgx.NamedArgs{
+			"id":             uuid.New(),
+			"cartId":         owner.CartId,
+			"userId":         owner.UserId,
+			"variantId":      variantId,
+			"quantity":       req.Quantity,
+			"note":           req.Note,
+			"purchaseStatus": StatusInCart,
+		}
+		if owner.isGuest() {
+			_, err = tx.Exec(ctx, `INSERT INTO guest_cart_items (cart_id, product_id, variant_id, note, quantity)
+			SELECT @cartId, product_id, @variantId, @note, @quantity FROM product_variants WHERE id = @variantId
+			ON CONFLICT (cart_id, variant_id) DO UPDATE SET
+				quantity = guest_cart_items.quantity + EXCLUDED.quantity,
+				note = COALESCE(EXCLUDED.note, guest_cart_items.note)`, args)
+		} else if current > 0 {
+			_, err = tx.Exec(ctx, `UPDATE orders SET quantity = quantity + @quantity, note = COALESCE(@note, note), updated_at = now()
+			WHERE user_id = @userId AND variant_id = @variantId AND purchase_status = @purchaseStatus`, args)
+		} else {
+			_, err = tx.Exec(ctx, `INSERT INTO orders (id, product_id, variant_id, user_id, note, purchase_source, purchase_status, quantity)
+			SELECT @id, product_id, @variantId, @userId, @note, 'cart', @purchaseStatus, @quantity FROM product_variants WHERE id = @variantId`, args)
+		}
+		return err
+	})
+	return owner, err
+}
+
+// setCartItem replaces the quantity, and the note when one is given, of a line.
+func setCartItem(ctx context.Context, owner cartOwner, req cartItemRequest) error {
+	return pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		if err := lockCart(ctx, tx, owner); err != nil {
+			return err
+		}
+
+		_, stock, err := variantStock(ctx, tx, "", req.VariantId)
+		if err != nil {
+			return err
+		}
+		if req.Quantity > stock {
+			return errInsufficientStock
+		}
+
+		args := pgx.NamedArgs{
+			"cartId":         owner.CartId,
+			"userId":         owner.UserId,
+			"variantId":      req.VariantId,
+			"quantity":       req.Quantity,
+			"note":           req.Note,
+			"purchaseStatus": StatusInCart,
+		}
+		query := `UPDATE orders SET quantity = @quantity, note = COALESCE(@note, note), updated_at = now()
+		WHERE user_id = @userId AND variant_id = @variantId AND purchase_status = @purchaseStatus`
+		if owner.isGuest() {
+			query = `UPDATE guest_cart_items SET quantity = @quantity, note = COALESCE(@note, note)
+			WHERE cart_id = @cartId AND variant_id = @variantId`
+		}
+
+		tag, err := tx.Exec(ctx, query, args)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return errCartLineNotFound
+		}
+		return nil
+	})
+}
+
+func removeCartItem(ctx context.Context, owner cartOwner, variantId string) error {
+	query := `DELETE FROM orders WHERE user_id = $1 AND variant_id = $2 AND purchase_status = $3`
+	args := []any{owner.UserId, variantId, StatusInCart}
+	if owner.isGuest() {
+		query = `WITH touched AS (UPDATE guest_carts SET updated_at = now() WHERE id = $1)
+		DELETE FROM guest_cart_items WHERE cart_id = $1 AND variant_id = $2`
+		args = []any{owner.CartId, variantId}
+	}
+
+	tag, err := db.PG.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errCartLineNotFound
+	}
+	return nil
+}
+
+func clearCart(ctx context.Context, owner cartOwner) error {
+	var err error
+	if owner.isGuest() {
+		_, err = db.PG.Exec(ctx, `DELETE FROM guest_carts WHERE id = $1`, owner.CartId)
+	} else {
+		_, err = db.PG.Exec(ctx, `DELETE FROM orders WHERE user_id = $1 AND purchase_status = $2`, owner.UserId, StatusInCart)
+	}
+	return err
+}
+
+// MergeGuestCart moves a guest cart into the cart of a user who just logged in.
+// Variants already in the user's cart get the guest quantity added, the others
+// become new lines as long as the line limit allows. Both are capped at what the
+// variant has available, login can't fail over stock the way adding an item
+// does. Lines that don't fit, or whose variant sold out, stay in the guest cart.
+func MergeGuestCart(ctx context.Context, userId string, cartId string) error {
+	if _, err := uuid.Parse(cartId); err != nil {
+		return nil
+	}
+
+	return pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		err := lockCart(ctx, tx, cartOwner{UserId: userId})
+		if err != nil {
+			return err
+		}
+		err = lockCart(ctx, tx, cartOwner{CartId: cartId})
+		if err == errGuestCartNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		query := `WITH merged AS (
+			UPDATE orders o SET quantity = GREATEST(o.quantity, LEAST(o.quantity + g.quantity, a.available)), updated_at = now()
+			FROM guest_cart_items g JOIN variant_availability a ON a.variant_id = g.variant_id
+			WHERE g.cart_id = $2 AND o.user_id = $1 AND o.variant_id = g.variant_id AND o.purchase_status = $3
+			RETURNING o.variant_id
+		)
+		DELETE FROM guest_cart_items g USING merged WHERE g.cart_id = $2 AND g.variant_id = merged.variant_id`
+		if _, err = tx.Exec(ctx, query, userId, cartId, StatusInCart); err != nil {
+			return err
+		}
+
+		var lines int
+		query = `SELECT count(*) FROM orders WHERE user_id = $1 AND purchase_status = $2`
+		if err = tx.QueryRow(ctx, query, userId, StatusInCart).Scan(&lines); err != nil {
+			return err
+		}
+
+		query = `WITH moved AS (
+			DELETE FROM guest_cart_items WHERE cart_id = $2 AND variant_id IN (
+				SELECT g.variant_id FROM guest_cart_items g
+				JOIN product_variants v ON v.id = g.variant_id
+				JOIN products p ON p.id = v.product_id
+				JOIN variant_availability a ON a.variant_id = v.id
+				WHERE g.cart_id = $2 AND p.deleted_at IS NULL AND v.deleted_at IS NULL AND a.available > 0
+				ORDER BY g.created_at, g.variant_id
+				LIMIT $4
+			)
+			RETURNING product_id, variant_id, note, quantity
+		)
+		INSERT INTO orders (id, product_id, variant_id, user_id, note, purchase_source, purchase_status, quantity)
+		SELECT gen_random_uuid(), m.product_id, m.variant_id, $1, m.note, 'cart', $3, LEAST(m.quantity, a.available)
+		FROM moved m JOIN variant_availability a ON a.variant_id = m.variant_id`
+		if _, err = tx.Exec(ctx, query, userId, cartId, StatusInCart, max(MaxCartLines-lines, 0)); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM guest_carts c WHERE id = $1
+		AND NOT EXISTS (SELECT 1 FROM guest_cart_items g WHERE g.cart_id = c.id)`, cartId)
+		return err
+	})
+}
+
+func cartError(err error) responseCart {
+	code := 500
+	message := httperrors.C500
+	switch err {
+	case errCartLineNotFound, errGuestCartNotFound, errProductNotFound, errVariantNotFound:
+		code, message = 404, err.Error()
+	case errCartFull, errInsufficientStock, errVariantRequired:
+		code, message = 400, err.Error()
+	default:
+		log.Println(err.Error())
+	}
+	return responseCart{
+		Status: "failed",
+		Data:   nil,
+		Errors: &httperrors.Errors{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+func cartResponse(ctx context.Context, owner cartOwner) responseCart {
+	c, err := loadCart(ctx, owner)
+	if err != nil {
+		return cartError(err)
+	}
+	return responseCart{
+		Status: "success",
+		Data:   c,
+		Errors: nil,
+	}
+}
+
+func invalidCartInput() responseCart {
+	return responseCart{
+		Status: "failed",
+		Data:   nil,
+		Errors: &httperrors.Errors{
+			Code:    400,
+			Message: "Bad Request: Invalid input data",
+		},
+	}
+}
+
+func getCart(owner cartOwner) responseCart {
+	return cartResponse(context.Background(), owner)
+}
+
+func postCartItem(owner cartOwner, req cartItemRequest) responseCart {
+	for _, id := range []string{req.ProductId, req.VariantId} {
+		if _, err := uuid.Parse(id); id != "" && err != nil {
+			return invalidCartInput()
+		}
+	}
+	if req.ProductId == "" && req.VariantId == "" || req.Quantity <= 0 {
+		return invalidCartInput()
+	}
+
+	ctx := context.Background()
+	owner, err := addCartItem(ctx, owner, req)
+	if err != nil {
+		return cartError(err)
+	}
+	return cartResponse(ctx, owner)
+}
+
+func putCartItem(owner cartOwner, req cartItemRequest) responseCart {
+	if req.Quantity <= 0 {
+		return invalidCartInput()
+	}
+	if owner.isGuest() && owner.CartId == "" {
+		return cartError(errGuestCartNotFound)
+	}
+
+	ctx := context.Background()
+	if err := setCartItem(ctx, owner, req); err != nil {
+		return cartError(err)
+	}
+	return cartResponse(ctx, owner)
+}
+
+func deleteCartItem(owner cartOwner, variantId string) responseCart {
+	if owner.isGuest() && owner.CartId == "" {
+		return cartError(errGuestCartNotFound)
+	}
+
+	ctx := context.Background()
+	if err := removeCartItem(ctx, owner, variantId); err != nil {
+		return cartError(err)
+	}
+	return cartResponse(ctx, owner)
+}
+
+func deleteCart(owner cartOwner) responseCart {
+	ctx := context.Background()
+	if !owner.isGuest() || owner.CartId != "" {
+		if err := clearCart(ctx, owner); err != nil {
+			return cartError(err)
+		}
+	}
+	if owner.isGuest() {
+		owner.CartId = ""
+	}
+	return cartResponse(ctx, owner)
+}
@@ -0,0 +1,102 @@
+package order
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dikletscode/isyana-store/middleware"
+	"github.com/dikletscode/isyana-store/pkg/router"
+	"github.com/google/uuid"
+)
+
+// cartOwnerFromRequest picks the logged in user, or the guest cart named in
+// X-Cart-Id. It reports false when the header is not a cart id.
+func cartOwnerFromRequest(r *http.Request) (cartOwner, bool) {
+	if userId, ok := middleware.UserFromContext(r.Context())["jti"].(string); ok {
+		return cartOwner{UserId: userId}, true
+	}
+
+	cartId := r.Header.Get(CartHeader)
+	if cartId == "" {
+		return cartOwner{}, true
+	}
+	if _, err := uuid.Parse(cartId); err != nil {
+		return cartOwner{}, false
+	}
+	return cartOwner{CartId: cartId}, true
+}
+
+func writeCart(w http.ResponseWriter, resp responseCart, successCode int) {
+	if resp.Status == "success" {
+		if resp.Data != nil && resp.Data.CartId != nil {
+			w.Header().Set(CartHeader, *resp.Data.CartId)
+		}
+		w.WriteHeader(successCode)
+	} else {
+		w.WriteHeader(resp.Errors.Code)
+	}
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+	}
+}
+
+// CartRouter serves the cart of the logged in user, or of a guest identified by
+// X-Cart-Id. Guests get their cart id back in the response and in X-Cart-Id
+// after the first add; sending it on login moves the items into the user's cart.
+func CartRouter(rt *router.Router) {
+	carts := rt.Group("/cart", middleware.OptionalAuth)
+
+	carts.Get("", func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := cartOwnerFromRequest(r)
+		if !ok {
+			writeCart(w, invalidCartInput(), http.StatusOK)
+			return
+		}
+		writeCart(w, getCart(owner), http.StatusOK)
+	})
+
+	carts.Delete("", func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := cartOwnerFromRequest(r)
+		if !ok {
+			writeCart(w, invalidCartInput(), http.StatusOK)
+			return
+		}
+		writeCart(w, deleteCart(owner), http.StatusOK)
+	})
+
+	carts.Post("/items", func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := cartOwnerFromRequest(r)
+
+		var req cartItemRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if !ok || err != nil {
+			writeCart(w, invalidCartInput(), http.StatusCreated)
+			return
+		}
+		writeCart(w, postCartItem(owner, req), http.StatusCreated)
+	})
+
+	carts.Put("/items/{variantId:uuid}", func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := cartOwnerFromRequest(r)
+
+		var req cartItemRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		req.VariantId = router.Param(r, "variantId")
+		if !ok || err != nil {
+			writeCart(w, invalidCartInput(), http.StatusOK)
+			return
+		}
+		writeCart(w, putCartItem(owner, req), http.StatusOK)
+	})
+
+	carts.Delete("/items/{variantId:uuid}", func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := cartOwnerFromRequest(r)
+		if !ok {
+			writeCart(w, invalidCartInput(), http.StatusOK)
+			return
+		}
+		writeCart(w, deleteCartItem(owner, router.Param(r, "variantId")), http.StatusOK)
+	})
+
+}
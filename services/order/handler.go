@@ -10,7 +10,6 @@ import (
 	"github.com/dikletscode/isyana-store/pkg/httperrors"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type order struct {
@@ -23,6 +22,8 @@ type order struct {
 	Quantity       int       `json:"quantity"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+	UnitPrice      *int      `json:"unit_price"`
+	VariantId      string    `json:"variant_id"`
 }
 
 type response struct {
@@ -37,8 +38,14 @@ type responseArr struct {
 	Errors *httperrors.Errors `json:"errors"`
 }
 
+// addToOrder is the older way to put an item in the cart. It goes through
+// addCartItem like POST /cart/items, so the line limit, the stock check and
+// adding to an existing line work the same on both.
 func addToOrder(newOrder order) response {
 	_, err := uuid.Parse(newOrder.ProductId)
+	if newOrder.VariantId != "" && err == nil {
+		_, err = uuid.Parse(newOrder.VariantId)
+	}
 
 	if newOrder.Quantity <= 0 || err != nil {
 		// log.Println(err.Error())
@@ -51,122 +58,29 @@ func addToOrder(newOrder order) response {
 			},
 		}
 	}
-	var count int
 
-	query := `SELECT count(*) FROM orders where user_id=$1`
-	err = db.PG.QueryRow(context.Background(), query, *newOrder.UserId).Scan(&count)
+	ctx := context.Background()
+	owner := cartOwner{UserId: *newOrder.UserId}
+	_, err = addCartItem(ctx, owner, cartItemRequest{
+		ProductId: newOrder.ProductId,
+		VariantId: newOrder.VariantId,
+		Quantity:  newOrder.Quantity,
+		Note:      newOrder.Note,
+	})
 	if err != nil {
-
-		log.Println(err.Error())
-
-		return response{
-			Status: "failed",
-			Data:   nil,
-			Errors: &httperrors.Errors{
-				Code:    500,
-				Message: httperrors.C500,
-			},
-		}
-	}
-	if count >= 20 {
-		return response{
-			Status: "failed",
-			Data:   nil,
-			Errors: &httperrors.Errors{
-				Code:    400,
-				Message: "Cart Limit Exceeded: Please remove items to proceed.",
-			},
-		}
-	}
-
-	// query := `INSERT INTO orders
-	// (id, product_id, user_id, note, purchase_source, purchase_status, quantity)
-	// SELECT
-	// @id, @productId, @userId, @note, @purchaseSource, @purchaseStatus, @quantity
-	// WHERE (SELECT COUNT(*) FROM orders WHERE user_id = @userId) < 20
-	// `
-	var stock int
-	query = `SELECT stock FROM products where  id=$1`
-	err = db.PG.QueryRow(context.Background(), query, newOrder.ProductId).Scan(&stock)
-	if err != nil {
-		log.Println(err.Error())
-		return response{
-			Status: "failed",
-			Data:   nil,
-			Errors: &httperrors.Errors{
-				Code:    500,
-				Message: httperrors.C500,
-			},
-		}
-	}
-	if newOrder.Quantity > stock {
-
 		return response{
 			Status: "failed",
 			Data:   nil,
-			Errors: &httperrors.Errors{
-				Code:    400,
-				Message: "Insufficient stock for items ",
-			},
+			Errors: cartError(err).Errors,
 		}
 	}
 
-	query = `INSERT INTO orders
-	(id, product_id, user_id, note, purchase_source, purchase_status, quantity)
-	VALUES
-	(@id, @productId, @userId, @note, @purchaseSource, @purchaseStatus, @quantity)`
-
-	newOrder.Id = uuid.New().String()
-	args := pgx.NamedArgs{
-		"id":             newOrder.Id,
-		"productId":      newOrder.ProductId,
-		"userId":         *newOrder.UserId,
-		"note":           &newOrder.Note,
-		"purchaseSource": newOrder.PurchaseSource,
-		"purchaseStatus": newOrder.PurchaseStatus,
-		"quantity":       newOrder.Quantity,
+	query := `SELECT * FROM orders WHERE user_id = $1 AND product_id = $2 AND ($3 = '' OR variant_id::text = $3) AND purchase_status = $4`
+	rows, err := db.PG.Query(ctx, query, owner.UserId, newOrder.ProductId, newOrder.VariantId, StatusInCart)
+	if err == nil {
+		newOrder, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[order])
 	}
-
-	_, err = db.PG.Exec(context.Background(), query, args)
-
-	// fmt.Printf("%v ==> ", data)
 	if err != nil {
-
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			if pgErr.ConstraintName == "orders_product_id_user_id_key" {
-				query = `UPDATE orders SET
-			 quantity=@quantity
-			 where product_id=@productId AND user_id=@userId `
-
-				args := pgx.NamedArgs{
-
-					"productId": newOrder.ProductId,
-					"userId":    *newOrder.UserId,
-					"quantity":  newOrder.Quantity,
-				}
-
-				_, err = db.PG.Exec(context.Background(), query, args)
-
-				if err != nil {
-					log.Println(err.Error())
-					return response{
-						Status: "failed",
-						Data:   nil,
-						Errors: &httperrors.Errors{
-							Code:    500,
-							Message: httperrors.C500,
-						},
-					}
-				}
-				return response{
-					Status: "success",
-					Data:   &newOrder,
-					Errors: nil,
-				}
-			}
-		}
-
 		log.Println(err.Error())
 		return response{
 			Status: "failed",
@@ -183,8 +97,9 @@ func addToOrder(newOrder order) response {
 		Data:   &newOrder,
 		Errors: nil,
 	}
-
 }
+
+// updateOrder only edits cart lines; purchase_status moves through transitionOrder.
 func updateOrder(newOrder order) response {
 	_, err := uuid.Parse(newOrder.Id)
 
@@ -201,23 +116,35 @@ func updateOrder(newOrder order) response {
 	}
 
 	query := `UPDATE orders SET
-			  note=@note, purchase_source=@purchaseSource, purchase_status=@purchaseStatus, quantity=@quantity 
-			  where product_id=@productId AND user_id=@userId `
+			  note=@note, quantity=@quantity, updated_at=now()
+			  where id=@id AND user_id=@userId AND purchase_status=@purchaseStatus
+			  RETURNING *`
 
 	args := pgx.NamedArgs{
 		"id":             newOrder.Id,
-		"productId":      newOrder.ProductId,
 		"userId":         *newOrder.UserId,
-		"note":           &newOrder.Note,
-		"purchaseSource": newOrder.PurchaseSource,
-		"purchaseStatus": newOrder.PurchaseStatus,
+		"note":           newOrder.Note,
+		"purchaseStatus": StatusInCart,
 		"quantity":       newOrder.Quantity,
 	}
 
-	_, err = db.PG.Exec(context.Background(), query, args)
+	rows, err := db.PG.Query(context.Background(), query, args)
+	if err == nil {
+		newOrder, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[order])
+	}
 
 	// fmt.Printf("%v ==> ", data)
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    404,
+					Message: "Cart item not found",
+				},
+			}
+		}
 
 		log.Println(err.Error())
 
@@ -356,3 +283,146 @@ func getMyOrderById(userId string, orderId string) response {
 	}
 
 }
+
+type statusRequest struct {
+	Status string `json:"status"`
+}
+
+type responseHistory struct {
+	Status string             `json:"status"`
+	Data   []statusHistory    `json:"data"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+// transitionOrder lets the buyer or the seller of the ordered product move an
+// order along the state machine. When the caller is both, the seller role wins
+// if it permits the move.
+func transitionOrder(userId string, isSeller bool, orderId string, to string) response {
+	ctx := context.Background()
+	var updated order
+
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		var buyerId, sellerId, from string
+		query := `SELECT o.user_id::text, p.seller_id::text, o.purchase_status
+		FROM orders o JOIN products p ON p.id = o.product_id WHERE o.id = $1`
+		err := tx.QueryRow(ctx, query, orderId).Scan(&buyerId, &sellerId, &from)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrOrderNotFound
+			}
+			return err
+		}
+
+		var actors []string
+		if isSeller && sellerId == userId {
+			actors = append(actors, ActorSeller)
+		}
+		if buyerId == userId {
+			actors = append(actors, ActorBuyer)
+		}
+		if len(actors) == 0 {
+			return ErrOrderNotFound
+		}
+
+		actor := actors[len(actors)-1]
+		for _, a := range actors {
+			if CanTransition(from, to, a) {
+				actor = a
+				break
+			}
+		}
+
+		err = Transition(ctx, tx, []string{orderId}, to, actor, userId)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(ctx, `SELECT * FROM orders WHERE id = $1`, orderId)
+		if err != nil {
+			return err
+		}
+		updated, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[order])
+		return err
+	})
+
+	if err != nil {
+		var transitionErr *TransitionError
+		if errors.As(err, &transitionErr) {
+			return response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    409,
+					Message: "Conflict: " + transitionErr.Error(),
+				},
+			}
+		}
+		if err == ErrOrderNotFound {
+			return response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    404,
+					Message: "Order not found",
+				},
+			}
+		}
+
+		log.Println(err.Error())
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	return response{
+		Status: "success",
+		Data:   &updated,
+		Errors: nil,
+	}
+}
+
+func getOrderHistory(userId string, orderId string) responseHistory {
+	query := `SELECT h.id::text, h.order_id::text, h.from_status, h.to_status, h.actor, h.actor_id::text, h.created_at
+	FROM order_status_history h
+	JOIN orders o ON o.id = h.order_id
+	JOIN products p ON p.id = o.product_id
+	WHERE h.order_id = $1 AND (o.user_id = $2 OR p.seller_id = $2)
+	ORDER BY h.created_at`
+
+	rows, err := db.PG.Query(context.Background(), query, orderId, userId)
+	if err != nil {
+		log.Println(err.Error())
+		return responseHistory{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	history, err := pgx.CollectRows(rows, pgx.RowToStructByPos[statusHistory])
+	if err != nil {
+		log.Println(err.Error())
+		return responseHistory{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	return responseHistory{
+		Status: "success",
+		Data:   history,
+		Errors: nil,
+	}
+}
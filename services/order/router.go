@@ -3,183 +3,207 @@ package order
 import (
 	"encoding/json"
 	"net/http"
-	"strings"
 
 	"github.com/dikletscode/isyana-store/middleware"
 	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/router"
 )
 
-func SellerRouter() {
-	http.Handle("/order", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		if r.Method == http.MethodPost {
-
-			claims := middleware.UserFromContext(r.Context())
-
-			jwtUserID, ok := claims["jti"].(string)
-
-			decoder := json.NewDecoder(r.Body)
-			var incomingOrder order
-			var resp response
-			err := decoder.Decode(&incomingOrder)
-			if !ok || err != nil {
-				// Handle the case where "jti" is not a string
-				resp = response{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid input data",
-					},
-				}
-			}
-			purchaseStatus := "IN_CART"
-			purchaseSource := "cart"
-			incomingOrder.UserId = &jwtUserID
-			incomingOrder.PurchaseStatus = purchaseStatus
-			incomingOrder.PurchaseSource = purchaseSource
-			resp = addToOrder(incomingOrder)
-
-			if resp.Status == "success" {
-				w.WriteHeader(http.StatusCreated)
-			} else {
-				w.WriteHeader(resp.Errors.Code)
-			}
-			err = json.NewEncoder(w).Encode(resp)
-			if err != nil {
-				http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
-			}
+func SellerRouter(rt *router.Router) {
+	orders := rt.Group("/order", middleware.AuthMiddleware)
 
-		} else if r.Method == http.MethodGet {
+	orders.Post("", func(w http.ResponseWriter, r *http.Request) {
 
-			var resp responseArr
+		claims := middleware.UserFromContext(r.Context())
 
-			claims := middleware.UserFromContext(r.Context())
+		jwtUserID, ok := claims["jti"].(string)
 
-			jwtUserID, ok := claims["jti"].(string)
-			if !ok {
-				// Handle the case where "jti" is not a string
-				resp = responseArr{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid input data",
-					},
-				}
+		decoder := json.NewDecoder(r.Body)
+		var incomingOrder order
+		var resp response
+		err := decoder.Decode(&incomingOrder)
+		if !ok || err != nil {
+			// Handle the case where "jti" is not a string
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
 			}
-			resp = getMyOrders(jwtUserID)
+		}
+		purchaseStatus := StatusInCart
+		purchaseSource := "cart"
+		incomingOrder.UserId = &jwtUserID
+		incomingOrder.PurchaseStatus = purchaseStatus
+		incomingOrder.PurchaseSource = purchaseSource
+		resp = addToOrder(incomingOrder)
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
 
-			if resp.Status == "success" {
-				w.WriteHeader(http.StatusOK)
-			} else {
-				w.WriteHeader(resp.Errors.Code)
-			}
-			err := json.NewEncoder(w).Encode(resp)
-			if err != nil {
-				http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+	}, middleware.Idempotency)
+
+	orders.Get("", func(w http.ResponseWriter, r *http.Request) {
+
+		var resp responseArr
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, ok := claims["jti"].(string)
+		if !ok {
+			// Handle the case where "jti" is not a string
+			resp = responseArr{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
 			}
+		}
+		resp = getMyOrders(jwtUserID)
 
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
 		} else {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-
-	}), nil))
-
-	http.Handle("/order/", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPut {
-			var resp response
-			breakUrl := strings.Split(r.URL.Path, "/")
-			if len(breakUrl) <= 2 {
-				resp = response{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid URL format",
-					},
-				}
-			}
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
 
-			claims := middleware.UserFromContext(r.Context())
-
-			jwtUserID, ok := claims["jti"].(string)
-
-			decoder := json.NewDecoder(r.Body)
-			var incomingOrder order
-			incomingOrder.Id = breakUrl[len(breakUrl)-1]
-			incomingOrder.UserId = &jwtUserID
-
-			err := decoder.Decode(&incomingOrder)
-			if !ok || err != nil {
-				// Handle the case where "jti" is not a string
-				resp = response{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid input data",
-					},
-				}
-			}
-			resp = updateOrder(incomingOrder)
+	})
 
-			if resp.Status == "success" {
-				w.WriteHeader(http.StatusOK)
-			} else {
-				w.WriteHeader(resp.Errors.Code)
-			}
-			err = json.NewEncoder(w).Encode(resp)
-			if err != nil {
-				http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
-			}
+	orders.Put("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+		var resp response
 
-		} else if r.Method == http.MethodGet {
-			var resp response
-
-			breakUrl := strings.Split(r.URL.Path, "/")
-			if len(breakUrl) <= 2 {
-				resp = response{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid URL format",
-					},
-				}
-			}
-			claims := middleware.UserFromContext(r.Context())
-
-			jwtUserID, ok := claims["jti"].(string)
-			if !ok {
-				// Handle the case where "jti" is not a string
-				resp = response{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid input data",
-					},
-				}
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, ok := claims["jti"].(string)
+
+		decoder := json.NewDecoder(r.Body)
+		var incomingOrder order
+
+		err := decoder.Decode(&incomingOrder)
+		incomingOrder.Id = router.Param(r, "id")
+		incomingOrder.UserId = &jwtUserID
+		if !ok || err != nil {
+			// Handle the case where "jti" is not a string
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
 			}
-			orderId := breakUrl[len(breakUrl)-1]
-			resp = getMyOrderById(jwtUserID, orderId)
+		}
+		resp = updateOrder(incomingOrder)
 
-			if resp.Status == "success" {
-				w.WriteHeader(http.StatusOK)
-			} else {
-				w.WriteHeader(resp.Errors.Code)
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+
+	})
+
+	orders.Get("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+		var resp response
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, ok := claims["jti"].(string)
+		if !ok {
+			// Handle the case where "jti" is not a string
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
 			}
-			err := json.NewEncoder(w).Encode(resp)
-			if err != nil {
-				http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+		resp = getMyOrderById(jwtUserID, router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
+
+	orders.Post("/{id:uuid}/status", func(w http.ResponseWriter, r *http.Request) {
+		var resp response
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, ok := claims["jti"].(string)
+
+		decoder := json.NewDecoder(r.Body)
+		var req statusRequest
+		err := decoder.Decode(&req)
+		if !ok || err != nil {
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
 			}
 		} else {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
+			isSeller := middleware.HasRole(r.Context(), middleware.RoleSeller)
+			resp = transitionOrder(jwtUserID, isSeller, router.Param(r, "id"), req.Status)
 		}
 
-	}), nil))
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
+
+	orders.Get("/{id:uuid}/history", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		resp := getOrderHistory(jwtUserID, router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
 
 }
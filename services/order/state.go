@@ -0,0 +1,192 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	StatusInCart     = "IN_CART"
+	StatusPending    = "PENDING"
+	StatusInProgress = "IN_PROGRESS"
+	StatusOnHold     = "ON_HOLD"
+	StatusShipped    = "SHIPPED"
+	StatusDelivered  = "DELIVERED"
+	StatusReturned   = "RETURNED"
+	StatusCancelled  = "CANCELLED"
+)
+
+const (
+	ActorBuyer  = "buyer"
+	ActorSeller = "seller"
+	ActorSystem = "system"
+)
+
+// transitions lists, per current status, the statuses an order may move to and
+// which actors may move it there.
+//
+//	IN_CART -> PENDING                  checkout started
+//	PENDING -> CANCELLED                payment failed, the lines go back to the cart as new orders
+//	PENDING -> IN_PROGRESS              payment captured
+//	IN_PROGRESS <-> ON_HOLD             seller pauses fulfilment
+//	IN_PROGRESS -> SHIPPED              seller hands over to the courier
+//	SHIPPED -> DELIVERED                buyer confirms receipt
+//	any paid status -> RETURNED         refunded
+var transitions = map[string]map[string][]string{
+	StatusInCart: {
+		StatusPending: {ActorSystem},
+	},
+	StatusPending: {
+		StatusCancelled:  {ActorSystem},
+		StatusInProgress: {ActorSystem},
+		StatusReturned:   {ActorSystem},
+	},
+	StatusInProgress: {
+		StatusOnHold:   {ActorSeller},
+		StatusShipped:  {ActorSeller},
+		StatusReturned: {ActorSystem},
+	},
+	StatusOnHold: {
+		StatusInProgress: {ActorSeller},
+		StatusReturned:   {ActorSystem},
+	},
+	StatusShipped: {
+		StatusDelivered: {ActorBuyer, ActorSystem},
+		StatusReturned:  {ActorSystem},
+	},
+	StatusDelivered: {
+		StatusReturned: {ActorSystem},
+	},
+}
+
+var ErrOrderNotFound = errors.New("order not found")
+
+type TransitionError struct {
+	OrderId string
+	From    string
+	To      string
+	Actor   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("order %s can't move from %s to %s as %s", e.OrderId, e.From, e.To, e.Actor)
+}
+
+func CanTransition(from string, to string, actor string) bool {
+	for _, allowed := range transitions[from][to] {
+		if allowed == actor {
+			return true
+		}
+	}
+	return false
+}
+
+type statusHistory struct {
+	Id         string    `json:"id"`
+	OrderId    string    `json:"order_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Actor      string    `json:"actor"`
+	ActorId    *string   `json:"actor_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Transition moves every order in orderIds to status `to` inside tx and records
+// the change in order_status_history. Either all orders move or none do.
+// actorId may be empty for moves made by the system itself.
+func Transition(ctx context.Context, tx pgx.Tx, orderIds []string, to string, actor string, actorId string) error {
+	rows, err := tx.Query(ctx, `SELECT id::text, purchase_status FROM orders WHERE id = ANY($1) ORDER BY id FOR UPDATE`, orderIds)
+	if err != nil {
+		return err
+	}
+
+	current := map[string]string{}
+	for rows.Next() {
+		var id, status string
+		if err = rows.Scan(&id, &status); err != nil {
+			rows.Close()
+			return err
+		}
+		current[id] = status
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(current) != len(orderIds) {
+		return ErrOrderNotFound
+	}
+	for _, id := range orderIds {
+		if !CanTransition(current[id], to, actor) {
+			return &TransitionError{OrderId: id, From: current[id], To: to, Actor: actor}
+		}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE orders SET purchase_status = $1, updated_at = now() WHERE id = ANY($2)`, to, orderIds)
+	if err != nil {
+		return err
+	}
+
+	var recordedActor *string
+	if actorId != "" {
+		recordedActor = &actorId
+	}
+
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"order_status_history"},
+		[]string{"id", "order_id", "from_status", "to_status", "actor", "actor_id"},
+		pgx.CopyFromSlice(len(orderIds), func(i int) ([]any, error) {
+			return []any{uuid.New(), orderIds[i], current[orderIds[i]], to, actor, recordedActor}, nil
+		}),
+	)
+	return err
+}
+
+// ReturnToCart cancels PENDING orders after a failed payment and puts their
+// quantities back into the buyer's cart. The cancelled orders stay with the
+// failed transaction, which still references them, so the cart gets fresh
+// lines instead: a variant already in the cart has its line topped up, the
+// others get a new line while the cart has room for it, see MaxCartLines. The
+// lines that don't fit are only kept on the cancelled orders. Cart lines are
+// thereby never referenced by a transaction or a reservation and can always be
+// deleted.
+func ReturnToCart(ctx context.Context, tx pgx.Tx, orderIds []string, actorId string) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('cart:' || u.user_id::text))
+	FROM (SELECT DISTINCT user_id FROM orders WHERE id = ANY($1) ORDER BY user_id) u`, orderIds)
+	if err != nil {
+		return err
+	}
+	if err = Transition(ctx, tx, orderIds, StatusCancelled, ActorSystem, actorId); err != nil {
+		return err
+	}
+
+	query := `WITH returned AS (
+		SELECT user_id, variant_id, (array_agg(product_id))[1] AS product_id, SUM(quantity)::INTEGER AS quantity, min(created_at) AS created_at,
+		(array_agg(note ORDER BY created_at) FILTER (WHERE note IS NOT NULL))[1] AS note,
+		(array_agg(purchase_source ORDER BY created_at))[1] AS purchase_source
+		FROM orders WHERE id = ANY($1)
+		GROUP BY user_id, variant_id
+	), merged AS (
+		UPDATE orders c SET quantity = c.quantity + r.quantity, note = COALESCE(c.note, r.note), updated_at = now()
+		FROM returned r
+		WHERE c.user_id = r.user_id AND c.variant_id = r.variant_id AND c.purchase_status = $2
+		RETURNING c.user_id, c.variant_id
+	), fresh AS (
+		SELECT r.*, row_number() OVER (PARTITION BY r.user_id ORDER BY r.created_at, r.variant_id) AS n
+		FROM returned r
+		WHERE NOT EXISTS (SELECT 1 FROM merged m WHERE m.user_id = r.user_id AND m.variant_id = r.variant_id)
+	)
+	INSERT INTO orders (id, product_id, variant_id, user_id, note, purchase_source, purchase_status, quantity)
+	SELECT gen_random_uuid(), f.product_id, f.variant_id, f.user_id, f.note, f.purchase_source, $2, f.quantity
+	FROM fresh f
+	WHERE f.n <= $3 - (SELECT count(*) FROM orders c WHERE c.user_id = f.user_id AND c.purchase_status = $2)`
+	_, err = tx.Exec(ctx, query, orderIds, StatusInCart, MaxCartLines)
+	return err
+}
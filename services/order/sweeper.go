@@ -0,0 +1,55 @@
+package order
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dikletscode/isyana-store/db"
+)
+
+// GuestCartTTL is how long a guest cart is kept after it was last written to,
+// GUEST_CART_TTL takes a Go duration such as "720h".
+func GuestCartTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("GUEST_CART_TTL"))
+	if err != nil || ttl <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return ttl
+}
+
+// SweepExpiredGuestCarts runs until ctx is done. Every interval it deletes the
+// guest carts nobody wrote to for longer than GuestCartTTL, their items go with
+// them.
+func SweepExpiredGuestCarts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := expireGuestCarts(ctx); err != nil {
+			log.Println(err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expireGuestCarts deletes in batches so a large backlog doesn't hold locks for
+// long. A cart being written to has its row locked by lockCart and is skipped.
+func expireGuestCarts(ctx context.Context) error {
+	query := `DELETE FROM guest_carts WHERE id IN (
+		SELECT id FROM guest_carts WHERE updated_at < $1
+		LIMIT 1000 FOR UPDATE SKIP LOCKED
+	)`
+	for {
+		tag, err := db.PG.Exec(ctx, query, time.Now().Add(-GuestCartTTL()))
+		if err != nil || tag.RowsAffected() < 1000 {
+			return err
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package review
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/services/order"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const MaxReviewLength = 2000
+
+var (
+	errProductNotFound = errors.New("Product not found")
+	errReviewNotFound  = errors.New("Review not found")
+	errOwnProduct      = errors.New("Sellers can't review their own products")
+	errNotDelivered    = errors.New("Only buyers with a delivered order of this product can review it")
+	errReviewExists    = errors.New("You already reviewed this product, edit your review instead")
+	errInvalidReview   = errors.New("Bad Request: rating must be between 1 and 5 and the review at most 2000 characters")
+	errInvalidReply    = errors.New("Bad Request: reply must be between 1 and 2000 characters")
+)
+
+// review is public, it names the reviewer by full name rather than the username
+// they log in with.
+type review struct {
+	Id           string     `json:"id"`
+	ProductId    string     `json:"product_id"`
+	UserId       string     `json:"user_id"`
+	ReviewerName string     `json:"reviewer_name"`
+	Rating       int        `json:"rating"`
+	Body         *string    `json:"body"`
+	Reply        *string    `json:"reply"`
+	RepliedAt    *time.Time `json:"replied_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	Photos       []photo    `json:"photos" db:"-"`
+}
+
+type reviewRequest struct {
+	Rating int     `json:"rating"`
+	Body   *string `json:"body"`
+}
+
+type replyRequest struct {
+	Reply string `json:"reply"`
+}
+
+// ratingSummary describes every review of a product, Distribution counts the
+// reviews per star.
+type ratingSummary struct {
+	RatingCount   int         `json:"rating_count"`
+	RatingAverage *float64    `json:"rating_average"`
+	Distribution  map[int]int `json:"distribution"`
+}
+
+type reviewMeta struct {
+	Page    int           `json:"page"`
+	Limit   int           `json:"limit"`
+	Total   int           `json:"total"`
+	Summary ratingSummary `json:"summary"`
+}
+
+type response struct {
+	Status string             `json:"status"`
+	Data   *review            `json:"data"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+type responseArr struct {
+	Status string             `json:"status"`
+	Data   []review           `json:"data"`
+	Meta   *reviewMeta        `json:"meta"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+// reviewColumns matches the field order of review for RowToStructByPos.
+const reviewColumns = `r.id::text, r.product_id::text, r.user_id::text, COALESCE(NULLIF(TRIM(u.full_name), ''), 'Buyer'), r.rating, r.body, r.reply, r.replied_at, r.created_at, r.updated_at`
+
+const reviewFrom = `reviews r JOIN users u ON u.id = r.user_id`
+
+func failed(code int, message string) *httperrors.Errors {
+	return &httperrors.Errors{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// reviewError maps the errors of the review handlers to a response.
+func reviewError(err error) response {
+	var pgErr *pgconn.PgError
+	var code int
+	var message string
+	switch {
+	case err == errProductNotFound || err == errReviewNotFound || err == errPhotoNotFound:
+		code, message = 404, err.Error()
+	case err == errOwnProduct || err == errNotDelivered:
+		code, message = 403, err.Error()
+	case err == errReviewExists:
+		code, message = 409, err.Error()
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		code, message = 409, errReviewExists.Error()
+	case err == errInvalidReview || err == errInvalidReply:
+		code, message = 400, err.Error()
+	case err == errTooManyPhotos:
+		code, message = 422, err.Error()
+	default:
+		log.Println(err.Error())
+		code, message = 500, httperrors.C500
+	}
+	return response{
+		Status: "failed",
+		Data:   nil,
+		Errors: failed(code, message),
+	}
+}
+
+// validateReview trims the body, an empty one is stored as no body at all.
+func validateReview(req *reviewRequest) error {
+	if req.Rating < 1 || req.Rating > 5 {
+		return errInvalidReview
+	}
+	if req.Body != nil {
+		body := strings.TrimSpace(*req.Body)
+		if utf8.RuneCountInString(body) > MaxReviewLength {
+			return errInvalidReview
+		}
+		req.Body = &body
+		if body == "" {
+			req.Body = nil
+		}
+	}
+	return nil
+}
+
+// loadReview returns one review with its photos.
+func loadReview(ctx context.Context, q querier, reviewId string) (review, error) {
+	rows, err := q.Query(ctx, `SELECT `+reviewColumns+` FROM `+reviewFrom+` WHERE r.id = $1`, reviewId)
+	if err != nil {
+		return review{}, err
+	}
+	r, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[review])
+	if err == pgx.ErrNoRows {
+		return review{}, errReviewNotFound
+	}
+	if err != nil {
+		return review{}, err
+	}
+	r.Photos, err = loadPhotos(ctx, q, r.Id)
+	return r, err
+}
+
+// attachPhotos fills in the photos of a page of reviews with a single query.
+func attachPhotos(ctx context.Context, reviews []review) error {
+	ids := make([]string, len(reviews))
+	index := map[string]int{}
+	for i, r := range reviews {
+		ids[i] = r.Id
+		index[r.Id] = i
+		reviews[i].Photos = []photo{}
+	}
+	photos, err := loadPhotos(ctx, db.PG, ids...)
+	for _, p := range photos {
+		i := index[p.ReviewId]
+		reviews[i].Photos = append(reviews[i].Photos, p)
+	}
+	return err
+}
+
+func getReview(reviewId string) response {
+	r, err := loadReview(context.Background(), db.PG, reviewId)
+	if err != nil {
+		return reviewError(err)
+	}
+	return response{
+		Status: "success",
+		Data:   &r,
+		Errors: nil,
+	}
+}
+
+// getReviews lists the reviews of a product newest first, the summary covers
+// all of them.
+func getReviews(productId string, query url.Values) responseArr {
+	page, limit := 1, 20
+	var err error
+	if value := query.Get("page"); value != "" {
+		if page, err = strconv.Atoi(value); err != nil || page < 1 {
+			err = errors.New("page must be a positive number")
+		}
+	}
+	if value := query.Get("limit"); err == nil && value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > 100 {
+			err = errors.New("limit must be between 1 and 100")
+		}
+	}
+	if err != nil {
+		return responseArr{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: failed(400, "Bad Request: "+err.Error()),
+		}
+	}
+
+	ctx := context.Background()
+	summary := ratingSummary{Distribution: map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}}
+	err = db.PG.QueryRow(ctx, `SELECT rating_count, ROUND(rating_total::NUMERIC / NULLIF(rating_count, 0), 2)::FLOAT8
+	FROM products WHERE id = $1 AND deleted_at IS NULL`, productId).Scan(&summary.RatingCount, &summary.RatingAverage)
+	if err == pgx.ErrNoRows {
+		return responseArr{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: failed(404, errProductNotFound.Error()),
+		}
+	}
+
+	if err == nil {
+		var rows pgx.Rows
+		rows, err = db.PG.Query(ctx, `SELECT rating, count(*)::INTEGER FROM reviews WHERE product_id = $1 GROUP BY rating`, productId)
+		if err == nil {
+			var rating, count int
+			_, err = pgx.ForEachRow(rows, []any{&rating, &count}, func() error {
+				summary.Distribution[rating] = count
+				return nil
+			})
+		}
+	}
+
+	reviews := []review{}
+	if err == nil {
+		var rows pgx.Rows
+		rows, err = db.PG.Query(ctx, `SELECT `+reviewColumns+` FROM `+reviewFrom+`
+		WHERE r.product_id = $1
+		ORDER BY r.created_at DESC, r.id DESC
+		LIMIT $2 OFFSET $3`, productId, limit, (page-1)*limit)
+		if err == nil {
+			reviews, err = pgx.CollectRows(rows, pgx.RowToStructByPos[review])
+		}
+	}
+	if err == nil {
+		err = attachPhotos(ctx, reviews)
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		return responseArr{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: failed(500, httperrors.C500),
+		}
+	}
+
+	return responseArr{
+		Status: "success",
+		Data:   reviews,
+		Meta:   &reviewMeta{Page: page, Limit: limit, Total: summary.RatingCount, Summary: summary},
+		Errors: nil,
+	}
+}
+
+// postReview lets a buyer review a product once they have an order of it
+// marked delivered. One review per buyer and product, later ones are edits.
+func postReview(userId string, productId string, req reviewRequest) response {
+	if err := validateReview(&req); err != nil {
+		return reviewError(err)
+	}
+
+	ctx := context.Background()
+	var sellerId string
+	err := db.PG.QueryRow(ctx, `SELECT seller_id::text FROM products WHERE id = $1 AND deleted_at IS NULL`, productId).Scan(&sellerId)
+	if err == pgx.ErrNoRows {
+		return reviewError(errProductNotFound)
+	}
+	if err != nil {
+		return reviewError(err)
+	}
+	if sellerId == userId {
+		return reviewError(errOwnProduct)
+	}
+
+	var orderId string
+	err = db.PG.QueryRow(ctx, `SELECT id::text FROM orders
+	WHERE user_id = $1 AND product_id = $2 AND purchase_status = $3
+	ORDER BY updated_at DESC LIMIT 1`, userId, productId, order.StatusDelivered).Scan(&orderId)
+	if err == pgx.ErrNoRows {
+		return reviewError(errNotDelivered)
+	}
+	if err != nil {
+		return reviewError(err)
+	}
+
+	query := `INSERT INTO reviews (id, product_id, user_id, order_id, rating, body)
+	VALUES (@id, @productId, @userId, @orderId, @rating, @body)`
+	args := pgx.NamedArgs{
+		"id":        uuid.New().String(),
+		"productId": productId,
+		"userId":    userId,
+		"orderId":   orderId,
+		"rating":    req.Rating,
+		"body":      req.Body,
+	}
+	if _, err = db.PG.Exec(ctx, query, args); err != nil {
+		return reviewError(err)
+	}
+	return getReview(args["id"].(string))
+}
+
+// updateReview changes the rating and text of the caller's own review.
+func updateReview(userId string, reviewId string, req reviewRequest) response {
+	if err := validateReview(&req); err != nil {
+		return reviewError(err)
+	}
+
+	comTag, err := db.PG.Exec(context.Background(), `UPDATE reviews SET rating = $3, body = $4, updated_at = now()
+	WHERE id = $1 AND user_id = $2`, reviewId, userId, req.Rating, req.Body)
+	if err != nil {
+		return reviewError(err)
+	}
+	if comTag.RowsAffected() == 0 {
+		return reviewError(errReviewNotFound)
+	}
+	return getReview(reviewId)
+}
+
+// deleteReview removes a review, its author's or any for an admin. The photo
+// files go once the rows are gone, a failure there only leaves orphan files.
+func deleteReview(userId string, isAdmin bool, reviewId string) response {
+	ctx := context.Background()
+	var deleted int
+	var blobKeys []string
+	// the photos cascade with their review, the query still sees them and
+	// hands back their files
+	err := db.PG.QueryRow(ctx, `WITH gone AS (
+		DELETE FROM reviews WHERE id = $1 AND (user_id = $2 OR $3)
+		RETURNING id
+	)
+	SELECT (SELECT count(*) FROM gone),
+	COALESCE((SELECT array_agg(k) FROM review_photos p JOIN gone ON gone.id = p.review_id,
+		unnest(ARRAY[p.key, p.thumbnail_key]) k), '{}')`, reviewId, userId, isAdmin).Scan(&deleted, &blobKeys)
+	if err != nil {
+		return reviewError(err)
+	}
+	if deleted == 0 {
+		return reviewError(errReviewNotFound)
+	}
+
+	for _, key := range blobKeys {
+		if err := blobStore.Delete(ctx, key); err != nil {
+			log.Println(err.Error())
+		}
+	}
+	return response{
+		Status: "success",
+		Data:   nil,
+		Errors: nil,
+	}
+}
+
+// setReply answers a review on behalf of the seller of the product, nil
+// removes the answer.
+func setReply(sellerId string, reviewId string, reply *string) response {
+	if reply != nil {
+		text := strings.TrimSpace(*reply)
+		if text == "" || utf8.RuneCountInString(text) > MaxReviewLength {
+			return reviewError(errInvalidReply)
+		}
+		reply = &text
+	}
+
+	comTag, err := db.PG.Exec(context.Background(), `UPDATE reviews r
+	SET reply = $3, replied_at = CASE WHEN $3::TEXT IS NULL THEN NULL ELSE now() END
+	FROM products p
+	WHERE r.id = $1 AND p.id = r.product_id AND p.seller_id = $2`, reviewId, sellerId, reply)
+	if err != nil {
+		return reviewError(err)
+	}
+	if comTag.RowsAffected() == 0 {
+		return reviewError(errReviewNotFound)
+	}
+	return getReview(reviewId)
+}
@@ -0,0 +1,199 @@
+package review
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mime/multipart"
+	"time"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/media"
+	"github.com/dikletscode/isyana-store/pkg/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const MaxReviewPhotos = 5
+
+var (
+	errTooManyPhotos = errors.New("a review can have at most 5 photos")
+	errPhotoNotFound = errors.New("Photo not found")
+)
+
+// blobStore holds review photos, it is set when the routes are mounted.
+var blobStore storage.BlobStore = storage.NewLocalStore("")
+
+type photo struct {
+	ReviewId     string    `json:"-"`
+	Id           string    `json:"id"`
+	Key          string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	ContentType  string    `json:"content_type"`
+	Size         int       `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Position     int       `json:"position"`
+	CreatedAt    time.Time `json:"created_at"`
+	URL          string    `json:"url" db:"-"`
+	ThumbnailURL string    `json:"thumbnail_url" db:"-"`
+}
+
+// querier is satisfied by both the pool and a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// loadPhotos returns the photos of the reviews in display order.
+func loadPhotos(ctx context.Context, q querier, reviewIds ...string) ([]photo, error) {
+	rows, err := q.Query(ctx, `SELECT review_id::text, id::text, key, thumbnail_key, content_type, size, width, height, position, created_at
+	FROM review_photos WHERE review_id = ANY($1) ORDER BY review_id, position`, reviewIds)
+	if err != nil {
+		return nil, err
+	}
+	photos, err := pgx.CollectRows(rows, pgx.RowToStructByPos[photo])
+	for i := range photos {
+		photos[i].URL = media.URL(photos[i].Key)
+		photos[i].ThumbnailURL = media.URL(photos[i].ThumbnailKey)
+	}
+	return photos, err
+}
+
+// uploadPhotos appends the files to the photos of the caller's review. Every
+// file is checked before anything is stored, so one bad file rejects the batch.
+func uploadPhotos(userId string, reviewId string, files []*multipart.FileHeader) response {
+	images := make([]*media.Image, 0, len(files))
+	for _, file := range files {
+		img, err := media.ProcessFile(file)
+		if code := media.StatusCode(err); code != 0 {
+			return response{
+				Status: "failed",
+				Data:   nil,
+				Errors: failed(code, file.Filename+": "+err.Error()),
+			}
+		}
+		if err != nil {
+			log.Println(err.Error())
+			return response{
+				Status: "failed",
+				Data:   nil,
+				Errors: failed(500, httperrors.C500),
+			}
+		}
+		images = append(images, img)
+	}
+
+	ctx := context.Background()
+	var stored []string
+	var result review
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		// the review row lock keeps concurrent uploads from taking the same positions
+		var count int
+		err := tx.QueryRow(ctx, `SELECT (SELECT count(*) FROM review_photos WHERE review_id = r.id)
+		FROM reviews r WHERE r.id = $1 AND r.user_id = $2 FOR UPDATE`, reviewId, userId).Scan(&count)
+		if err == pgx.ErrNoRows {
+			return errReviewNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if count+len(images) > MaxReviewPhotos {
+			return errTooManyPhotos
+		}
+
+		for i, img := range images {
+			id := uuid.New().String()
+			key, thumbKey, err := media.Save(ctx, blobStore, "reviews/"+reviewId, id, img)
+			if err != nil {
+				return err
+			}
+			stored = append(stored, key, thumbKey)
+
+			query := `INSERT INTO review_photos (id, review_id, key, thumbnail_key, content_type, size, width, height, position)
+			VALUES (@id, @reviewId, @key, @thumbnailKey, @contentType, @size, @width, @height, @position)`
+			args := pgx.NamedArgs{
+				"id":           id,
+				"reviewId":     reviewId,
+				"key":          key,
+				"thumbnailKey": thumbKey,
+				"contentType":  img.ContentType,
+				"size":         len(img.Data),
+				"width":        img.Width,
+				"height":       img.Height,
+				"position":     count + i,
+			}
+			if _, err = tx.Exec(ctx, query, args); err != nil {
+				return err
+			}
+		}
+
+		result, err = loadReview(ctx, tx, reviewId)
+		return err
+	})
+
+	if err != nil {
+		for _, key := range stored {
+			if err := blobStore.Delete(ctx, key); err != nil {
+				log.Println(err.Error())
+			}
+		}
+		return reviewError(err)
+	}
+
+	return response{
+		Status: "success",
+		Data:   &result,
+		Errors: nil,
+	}
+}
+
+// deletePhoto removes a photo of the caller's review and closes the gap it
+// leaves in the order.
+func deletePhoto(userId string, reviewId string, photoId string) response {
+	ctx := context.Background()
+	var keys [2]string
+	var result review
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		var id string
+		err := tx.QueryRow(ctx, `SELECT id::text FROM reviews WHERE id = $1 AND user_id = $2 FOR UPDATE`, reviewId, userId).Scan(&id)
+		if err == pgx.ErrNoRows {
+			return errReviewNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var position int
+		err = tx.QueryRow(ctx, `DELETE FROM review_photos WHERE id = $1 AND review_id = $2
+		RETURNING key, thumbnail_key, position`, photoId, reviewId).Scan(&keys[0], &keys[1], &position)
+		if err == pgx.ErrNoRows {
+			return errPhotoNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE review_photos SET position = position - 1
+		WHERE review_id = $1 AND position > $2`, reviewId, position)
+		if err != nil {
+			return err
+		}
+		result, err = loadReview(ctx, tx, reviewId)
+		return err
+	})
+	if err != nil {
+		return reviewError(err)
+	}
+
+	for _, key := range keys {
+		if err := blobStore.Delete(ctx, key); err != nil {
+			log.Println(err.Error())
+		}
+	}
+	return response{
+		Status: "success",
+		Data:   &result,
+		Errors: nil,
+	}
+}
@@ -0,0 +1,29 @@
+package review
+
+import (
+	"context"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// Reputation is what the reviews of a seller's products add up to. Score runs
+// from 1 to 10 and stays nil until the seller has a review, see the
+// seller_reputation view for how it is weighted.
+type Reputation struct {
+	ReviewCount   int      `json:"review_count"`
+	RatingAverage *float64 `json:"rating_average"`
+	Score         *float64 `json:"score"`
+}
+
+// SellerReputation returns the reputation of a seller, the zero Reputation
+// for a seller without products.
+func SellerReputation(ctx context.Context, sellerId string) (Reputation, error) {
+	var r Reputation
+	err := db.PG.QueryRow(ctx, `SELECT review_count, rating_average, score FROM seller_reputation WHERE seller_id = $1`, sellerId).
+		Scan(&r.ReviewCount, &r.RatingAverage, &r.Score)
+	if err == pgx.ErrNoRows {
+		return Reputation{}, nil
+	}
+	return r, err
+}
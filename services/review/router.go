@@ -0,0 +1,240 @@
+package review
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dikletscode/isyana-store/middleware"
+	"github.com/dikletscode/isyana-store/pkg/media"
+	"github.com/dikletscode/isyana-store/pkg/router"
+	"github.com/dikletscode/isyana-store/pkg/storage"
+)
+
+// ReviewRouter mounts the reviews. Reading them is public like the products
+// they belong to, writing needs an account.
+func ReviewRouter(rt *router.Router, store storage.BlobStore) {
+	blobStore = store
+	products := rt.Group("/product")
+	reviews := rt.Group("/review")
+	buyerOnly := []router.Middleware{middleware.AuthMiddleware, middleware.RequireRole(middleware.RoleBuyer)}
+	sellerOnly := []router.Middleware{middleware.AuthMiddleware, middleware.RequireRole(middleware.RoleSeller)}
+
+	products.Get("/{id:uuid}/reviews", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		resp := getReviews(router.Param(r, "id"), r.URL.Query())
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
+
+	products.Post("/{id:uuid}/reviews", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		var incoming reviewRequest
+		var resp response
+		err := json.NewDecoder(r.Body).Decode(&incoming)
+		if err != nil {
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: failed(400, "Bad Request: Invalid input data"),
+			}
+		} else {
+			resp = postReview(jwtUserID, router.Param(r, "id"), incoming)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, buyerOnly...)
+
+	reviews.Get("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		resp := getReview(router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
+
+	reviews.Put("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		var incoming reviewRequest
+		var resp response
+		err := json.NewDecoder(r.Body).Decode(&incoming)
+		if err != nil {
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: failed(400, "Bad Request: Invalid input data"),
+			}
+		} else {
+			resp = updateReview(jwtUserID, router.Param(r, "id"), incoming)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, middleware.AuthMiddleware)
+
+	// admins may take down any review, everyone else only their own
+	reviews.Delete("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		resp := deleteReview(jwtUserID, middleware.HasRole(r.Context(), middleware.RoleAdmin), router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, middleware.AuthMiddleware)
+
+	reviews.Put("/{id:uuid}/reply", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		var incoming replyRequest
+		var resp response
+		err := json.NewDecoder(r.Body).Decode(&incoming)
+		if err != nil {
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: failed(400, "Bad Request: Invalid input data"),
+			}
+		} else {
+			resp = setReply(jwtUserID, router.Param(r, "id"), &incoming.Reply)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	reviews.Delete("/{id:uuid}/reply", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		resp := setReply(jwtUserID, router.Param(r, "id"), nil)
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	reviews.Post("/{id:uuid}/photos", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		var resp response
+		files, err := media.Files(w, r, "photos", MaxReviewPhotos)
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
+		}
+		if code := media.StatusCode(err); code != 0 {
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: failed(code, err.Error()),
+			}
+		} else if err != nil {
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: failed(400, "Bad Request: expected a multipart form with photos"),
+			}
+		} else {
+			resp = uploadPhotos(jwtUserID, router.Param(r, "id"), files)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, middleware.AuthMiddleware)
+
+	reviews.Delete("/{id:uuid}/photos/{photoId:uuid}", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		resp := deletePhoto(jwtUserID, router.Param(r, "id"), router.Param(r, "photoId"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, middleware.AuthMiddleware)
+
+}
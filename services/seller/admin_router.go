@@ -0,0 +1,31 @@
+package seller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dikletscode/isyana-store/middleware"
+	"github.com/dikletscode/isyana-store/pkg/router"
+)
+
+func AdminRouter(rt *router.Router) {
+	admin := rt.Group("/admin", middleware.AuthMiddleware, middleware.RequireRole(middleware.RoleAdmin))
+
+	// purge hard-deletes soft-deleted products and vouchers older than the
+	// retention window, ?retention=720h overrides PURGE_RETENTION
+	admin.Post("/purge", func(w http.ResponseWriter, r *http.Request) {
+
+		resp := postPurge(r.URL.Query().Get("retention"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
+
+}
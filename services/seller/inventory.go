@@ -0,0 +1,281 @@
+package seller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Reasons recorded in inventory_movements.
+const (
+	MovementRestock     = "restock"
+	MovementSale        = "sale"
+	MovementRefund      = "refund"
+	MovementAdjustment  = "adjustment"
+	MovementReservation = "reservation"
+)
+
+var errStockBelowReserved = errors.New("stock can't go below what pending checkouts hold")
+
+// StockMovement is one change to a variant's stock. Delta changes the stock of
+// the variant and of its product, Reserved changes the stock held by checkouts.
+type StockMovement struct {
+	ProductId   string
+	VariantId   string
+	Reason      string
+	Delta       int
+	Reserved    int
+	ReferenceId *string
+	ActorId     *string
+	Note        *string
+}
+
+// ApplyStockMovements is the only way stock changes: it moves the stock of the
+// variant and its product by each Delta and appends the movements to the
+// ledger, inside tx. stock_after in the ledger is the variant's stock.
+func ApplyStockMovements(ctx context.Context, tx pgx.Tx, movements ...StockMovement) error {
+	if len(movements) == 0 {
+		return nil
+	}
+
+	// rows are updated in id order, product before variant, so concurrent
+	// writers can't deadlock
+	sort.SliceStable(movements, func(i, j int) bool {
+		if movements[i].ProductId != movements[j].ProductId {
+			return movements[i].ProductId < movements[j].ProductId
+		}
+		return movements[i].VariantId < movements[j].VariantId
+	})
+
+	stockAfter := make([]int, len(movements))
+	for i, m := range movements {
+		if m.Delta != 0 {
+			_, err := tx.Exec(ctx, `UPDATE products SET stock = stock + $1, updated_at = now() WHERE id = $2`, m.Delta, m.ProductId)
+			if err != nil {
+				return err
+			}
+		}
+		query := `UPDATE product_variants SET stock = stock + $1, updated_at = now() WHERE id = $2 AND product_id = $3 RETURNING stock`
+		if m.Delta == 0 {
+			query = `SELECT stock + $1 FROM product_variants WHERE id = $2 AND product_id = $3`
+		}
+		if err := tx.QueryRow(ctx, query, m.Delta, m.VariantId, m.ProductId).Scan(&stockAfter[i]); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"inventory_movements"},
+		[]string{"id", "product_id", "variant_id", "reason", "delta", "reserved", "stock_after", "reference_id", "actor_id", "note"},
+		pgx.CopyFromSlice(len(movements), func(i int) ([]any, error) {
+			m := movements[i]
+			return []any{uuid.New(), m.ProductId, m.VariantId, m.Reason, m.Delta, m.Reserved, stockAfter[i], m.ReferenceId, m.ActorId, m.Note}, nil
+		}),
+	)
+	return err
+}
+
+// setStock records a seller's manual stock count of a variant as an adjustment.
+// The new stock can't drop below what checkouts currently hold, which it returns.
+func setStock(ctx context.Context, tx pgx.Tx, productId string, variantId string, sellerId string, stock int) (int, error) {
+	var current, reserved int
+	if _, err := tx.Exec(ctx, `SELECT id FROM products WHERE id = $1 FOR UPDATE`, productId); err != nil {
+		return 0, err
+	}
+	err := tx.QueryRow(ctx, `SELECT stock, reserved FROM variant_availability WHERE variant_id = $1 AND product_id = $2`, variantId, productId).Scan(&current, &reserved)
+	if err != nil || stock == current {
+		return reserved, err
+	}
+	if stock < reserved {
+		return reserved, errStockBelowReserved
+	}
+
+	return reserved, ApplyStockMovements(ctx, tx, StockMovement{
+		ProductId: productId,
+		VariantId: variantId,
+		Reason:    MovementAdjustment,
+		Delta:     stock - current,
+		ActorId:   &sellerId,
+	})
+}
+
+type movement struct {
+	Id          string    `json:"id"`
+	VariantId   string    `json:"variant_id"`
+	Reason      string    `json:"reason"`
+	Delta       int       `json:"delta"`
+	Reserved    int       `json:"reserved"`
+	StockAfter  int       `json:"stock_after"`
+	ReferenceId *string   `json:"reference_id"`
+	ActorId     *string   `json:"actor_id"`
+	Note        *string   `json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type pagination struct {
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+	Total int `json:"total"`
+}
+
+type responseMovements struct {
+	Status string             `json:"status"`
+	Data   []movement         `json:"data"`
+	Meta   *pagination        `json:"meta"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+type inventoryCheck struct {
+	ProductId      string `json:"product_id"`
+	Stock          int    `json:"stock"`
+	LedgerStock    int    `json:"ledger_stock"`
+	Reserved       int    `json:"reserved"`
+	LedgerReserved int    `json:"ledger_reserved"`
+	Movements      int    `json:"movements"`
+	Consistent     bool   `json:"consistent"`
+}
+
+type responseInventoryCheck struct {
+	Status string             `json:"status"`
+	Data   *inventoryCheck    `json:"data"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+func ownsProduct(ctx context.Context, sellerId string, productId string) (bool, error) {
+	var owns bool
+	query := `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND seller_id = $2)`
+	err := db.PG.QueryRow(ctx, query, productId, sellerId).Scan(&owns)
+	return owns, err
+}
+
+func productNotFound() *httperrors.Errors {
+	return &httperrors.Errors{
+		Code:    404,
+		Message: "Product not found",
+	}
+}
+
+func getInventoryMovements(sellerId string, productId string, query url.Values) responseMovements {
+	page, limit := 1, 50
+	var err error
+	if value := query.Get("page"); value != "" {
+		if page, err = strconv.Atoi(value); err != nil || page < 1 {
+			err = errors.New("page must be a positive number")
+		}
+	}
+	if value := query.Get("limit"); err == nil && value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > 200 {
+			err = errors.New("limit must be between 1 and 200")
+		}
+	}
+	if err != nil {
+		return responseMovements{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: &httperrors.Errors{
+				Code:    400,
+				Message: "Bad Request: " + err.Error(),
+			},
+		}
+	}
+
+	ctx := context.Background()
+	owns, err := ownsProduct(ctx, sellerId, productId)
+	if err == nil && !owns {
+		return responseMovements{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: productNotFound(),
+		}
+	}
+
+	movements := []movement{}
+	total := 0
+	if err == nil {
+		err = db.PG.QueryRow(ctx, `SELECT count(*) FROM inventory_movements WHERE product_id = $1`, productId).Scan(&total)
+	}
+	if err == nil {
+		var rows pgx.Rows
+		rows, err = db.PG.Query(ctx, `SELECT id::text, variant_id::text, reason, delta, reserved, stock_after, reference_id::text, actor_id::text, note, created_at
+		FROM inventory_movements WHERE product_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3`, productId, limit, (page-1)*limit)
+		if err == nil {
+			movements, err = pgx.CollectRows(rows, pgx.RowToStructByPos[movement])
+		}
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		return responseMovements{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	return responseMovements{
+		Status: "success",
+		Data:   movements,
+		Meta:   &pagination{Page: page, Limit: limit, Total: total},
+		Errors: nil,
+	}
+}
+
+// checkInventory rebuilds stock and reserved stock from the ledger and compares
+// them with products.stock and the active reservations.
+func checkInventory(sellerId string, productId string) responseInventoryCheck {
+	ctx := context.Background()
+	owns, err := ownsProduct(ctx, sellerId, productId)
+	if err == nil && !owns {
+		return responseInventoryCheck{
+			Status: "failed",
+			Data:   nil,
+			Errors: productNotFound(),
+		}
+	}
+
+	check := inventoryCheck{ProductId: productId}
+	if err == nil {
+		query := `SELECT a.stock, a.reserved,
+		COALESCE(SUM(m.delta), 0)::INTEGER, COALESCE(SUM(m.reserved), 0)::INTEGER, count(m.id)::INTEGER
+		FROM product_availability a LEFT JOIN inventory_movements m ON m.product_id = a.product_id
+		WHERE a.product_id = $1
+		GROUP BY a.stock, a.reserved`
+		err = db.PG.QueryRow(ctx, query, productId).Scan(&check.Stock, &check.Reserved, &check.LedgerStock, &check.LedgerReserved, &check.Movements)
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return responseInventoryCheck{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	check.Consistent = check.Stock == check.LedgerStock && check.Reserved == check.LedgerReserved
+	return responseInventoryCheck{
+		Status: "success",
+		Data:   &check,
+		Errors: nil,
+	}
+}
@@ -3,8 +3,8 @@ package seller
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
+	"net/url"
 	"time"
 
 	"github.com/dikletscode/isyana-store/db"
@@ -15,16 +15,24 @@ import (
 )
 
 type product struct {
-	Id          string     `json:"id"`
-	Name        string     `json:"name"`
-	Description *string    `json:"description"`
-	Price       int        `json:"price"`
-	Stock       int        `json:"stock"`
-	CategoryId  *int       `json:"category_id"`
-	SellerId    string     `json:"seller_id,omitempty"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
-	DeletedAt   *time.Time `json:"-"`
+	Id          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Price       int     `json:"price"`
+	Stock       int     `json:"stock"`
+	Available   int     `json:"available"`
+	// RatingAverage is nil until the product has a review
+	RatingCount   int        `json:"rating_count"`
+	RatingAverage *float64   `json:"rating_average"`
+	CategoryId    *string    `json:"category_id"`
+	SellerId      string     `json:"seller_id,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	DeletedAt     *time.Time `json:"-"`
+	// Images, Options and Variants are only filled in for a single product
+	Images   []productImage  `json:"images,omitempty" db:"-"`
+	Options  []productOption `json:"options,omitempty" db:"-"`
+	Variants []variant       `json:"variants,omitempty" db:"-"`
 }
 
 type response struct {
@@ -36,11 +44,42 @@ type response struct {
 type responseArr struct {
 	Status string             `json:"status"`
 	Data   []product          `json:"data"`
+	Meta   *cursorMeta        `json:"meta"`
 	Errors *httperrors.Errors `json:"errors"`
 }
 
+func isValidCategoryId(categoryId *string) bool {
+	if categoryId == nil {
+		return true
+	}
+	_, err := uuid.Parse(*categoryId)
+	return err == nil
+}
+
+// validateProduct holds the rules every product write goes through.
+func validateProduct(product product) error {
+	switch {
+	case len(product.Name) <= 5 || len(product.Name) >= 100:
+		return errors.New("name must be 6 to 99 characters long")
+	case product.Description == nil || len(*product.Description) >= 200:
+		return errors.New("description must be shorter than 200 characters")
+	case product.Price < 100 || product.Price > 100000000:
+		return errors.New("price must be between 100 and 100000000")
+	case product.Stock < 0:
+		return errors.New("stock can't be negative")
+	case !isValidCategoryId(product.CategoryId):
+		return errors.New("category_id is not a valid id")
+	}
+	return nil
+}
+
+func isUnknownCategory(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503" && pgErr.ConstraintName == "products_category_id_fkey"
+}
+
 func postProduct(sellerId string, product product) response {
-	if len(product.Name) <= 5 || len(*product.Description) >= 200 || len(product.Name) >= 100 || product.Price < 100 || product.Price > 100000000 || product.Stock < 0 {
+	if validateProduct(product) != nil {
 		// log.Println(err.Error())
 		return response{
 			Status: "failed",
@@ -52,7 +91,12 @@ func postProduct(sellerId string, product product) response {
 		}
 	}
 
-	query := `INSERT INTO products (id ,name, description, price, stock, seller_id) VALUES (@id, @name, @description, @price, @stock, @sellerId)`
+	if err := prepareVariants(&product); err != nil {
+		return invalidVariation(err)
+	}
+
+	// the product starts empty, the stock of every variant is booked as a restock
+	query := `INSERT INTO products (id ,name, description, price, stock, category_id, seller_id) VALUES (@id, @name, @description, @price, 0, @categoryId, @sellerId)`
 	id := uuid.New()
 	product.Id = id.String()
 	product.SellerId = sellerId
@@ -62,28 +106,31 @@ func postProduct(sellerId string, product product) response {
 		"name":        product.Name,
 		"description": product.Description,
 		"price":       product.Price,
-		"stock":       product.Stock,
+		"categoryId":  product.CategoryId,
 		"sellerId":    sellerId,
 	}
-	_, err := db.PG.Exec(context.Background(), query, args)
-
-	if err != nil {
-		log.Println(err.Error())
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, query, args); err != nil {
+			return err
+		}
+		return insertVariants(ctx, tx, &product)
+	})
 
+	if isUnknownCategory(err) {
 		return response{
 			Status: "failed",
 			Data:   nil,
 			Errors: &httperrors.Errors{
-				Code:    500,
-				Message: httperrors.C500,
+				Code:    400,
+				Message: "Bad Request: Unknown category id",
 			},
 		}
 	}
-	return response{
-		Status: "success",
-		Data:   &product,
-		Errors: nil,
+	if err != nil {
+		return variantError(err)
 	}
+	return getProductById(product.Id)
 
 }
 
@@ -111,7 +158,7 @@ func updateProduct(jwtId string, product product) response {
 		}
 	}
 
-	if len(product.Name) <= 5 || len(*product.Description) >= 200 || len(product.Name) >= 100 || product.Price < 100 || product.Price > 100000000 || product.Stock < 0 {
+	if validateProduct(product) != nil {
 		return response{
 			Status: "failed",
 			Data:   nil,
@@ -122,9 +169,10 @@ func updateProduct(jwtId string, product product) response {
 		}
 	}
 
+	// stock changes go through the ledger as an adjustment
 	query := `UPDATE products SET 
-	name=@name, description=@description, price=@price, stock=@stock
-	where id=@id AND seller_id=@sellerId
+	name=@name, description=@description, price=@price, category_id=@categoryId, updated_at=now()
+	where id=@id AND seller_id=@sellerId AND deleted_at IS NULL
 	`
 
 	args := pgx.NamedArgs{
@@ -132,12 +180,39 @@ func updateProduct(jwtId string, product product) response {
 		"name":        product.Name,
 		"description": product.Description,
 		"price":       product.Price,
-		"stock":       product.Stock,
+		"categoryId":  product.CategoryId,
 		"sellerId":    jwtId,
 	}
-	comTag, err := db.PG.Exec(context.Background(), query, args)
+	ctx := context.Background()
+	var rowsAffected int64
+	err = pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		comTag, err := tx.Exec(ctx, query, args)
+		if err != nil {
+			return err
+		}
+		rowsAffected = comTag.RowsAffected()
+		if rowsAffected == 0 {
+			return nil
+		}
 
-	if comTag.RowsAffected() == 0 {
+		// the stock of a single variant product can still be set here, with
+		// several variants it is set per variant
+		var stock, live int
+		var variantId string
+		err = tx.QueryRow(ctx, `SELECT p.stock, count(v.id)::INTEGER, COALESCE(min(v.id::text), '')
+		FROM products p LEFT JOIN product_variants v ON v.product_id = p.id AND v.deleted_at IS NULL
+		WHERE p.id = $1 GROUP BY p.stock`, product.Id).Scan(&stock, &live, &variantId)
+		if err != nil || (live != 1 && stock == product.Stock) {
+			return err
+		}
+		if live != 1 {
+			return errStockPerVariant
+		}
+		_, err = setStock(ctx, tx, product.Id, variantId, jwtId, product.Stock)
+		return err
+	})
+
+	if err == nil && rowsAffected == 0 {
 		return response{
 			Status: "failed",
 			Data:   nil,
@@ -147,23 +222,20 @@ func updateProduct(jwtId string, product product) response {
 			},
 		}
 	}
-	if err != nil {
-		log.Println(err.Error())
-
+	if isUnknownCategory(err) {
 		return response{
 			Status: "failed",
 			Data:   nil,
 			Errors: &httperrors.Errors{
-				Code:    500,
-				Message: httperrors.C500,
+				Code:    400,
+				Message: "Bad Request: Unknown category id",
 			},
 		}
 	}
-	return response{
-		Status: "success",
-		Data:   &product,
-		Errors: nil,
+	if err != nil {
+		return variantError(err)
 	}
+	return getProductById(product.Id)
 
 }
 
@@ -172,7 +244,7 @@ func getProductById(sellerId string) response {
 	var rows pgx.Rows
 	var err error
 
-	query = `SELECT * FROM products where id = $1`
+	query = `SELECT ` + productColumns + ` FROM ` + productFrom + ` WHERE p.id = $1 AND p.deleted_at IS NULL`
 	rows, err = db.PG.Query(context.Background(), query, sellerId)
 
 	if err != nil {
@@ -189,17 +261,25 @@ func getProductById(sellerId string) response {
 	}
 
 	product, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[product])
+	if err == nil {
+		product.Images, err = loadProductImages(context.Background(), db.PG, product.Id)
+	}
+	if err == nil {
+		product.Options, err = loadProductOptions(context.Background(), db.PG, product.Id)
+	}
+	if err == nil {
+		product.Variants, err = loadProductVariants(context.Background(), db.PG, product.Id)
+	}
 
+	if err == pgx.ErrNoRows {
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: productNotFound(),
+		}
+	}
 	if err != nil {
 		log.Println(err.Error())
-
-		if err == pgx.ErrNoRows {
-			return response{
-				Status: "sucess",
-				Data:   nil,
-				Errors: nil,
-			}
-		}
 		return response{
 			Status: "failed",
 			Data:   nil,
@@ -217,74 +297,51 @@ func getProductById(sellerId string) response {
 
 }
 
-func getProducts(categoryId string) responseArr {
-	var query string
-	var err error
-	var rows pgx.Rows
-
-	if categoryId == "" {
-		query = `SELECT * FROM products`
-		rows, err = db.PG.Query(context.Background(), query)
-	} else {
-		_, err = uuid.Parse(categoryId)
-		if err != nil {
-			return responseArr{
-				Status: "failed",
-				Data:   nil,
-				Errors: &httperrors.Errors{
-					Code:    400,
-					Message: `Invalid category id`,
-				},
-			}
-		}
-		query = `SELECT * FROM products where category_id = $1`
-		rows, err = db.PG.Query(context.Background(), query, categoryId)
-	}
-
+func getProducts(query url.Values) responseArr {
+	q, err := parseProductQuery(query)
 	if err != nil {
-		log.Println(err.Error())
-
 		return responseArr{
 			Status: "failed",
 			Data:   nil,
+			Meta:   nil,
 			Errors: &httperrors.Errors{
-				Code:    500,
-				Message: httperrors.C500,
+				Code:    400,
+				Message: "Bad Request: " + err.Error(),
 			},
 		}
 	}
 
-	product, err := pgx.CollectRows(rows, pgx.RowToStructByPos[product])
+	sql, args := q.sql()
+	rows, err := db.PG.Query(context.Background(), sql, args)
+	var products []product
+	if err == nil {
+		products, err = pgx.CollectRows(rows, pgx.RowToStructByPos[product])
+	}
 
 	if err != nil {
 		log.Println(err.Error())
-		var pgErr *pgconn.PgError
-
-		if errors.As(err, &pgErr) {
-			fmt.Printf(" vvvvvv %v ", pgErr)
-			if pgErr.Code == "22P02" {
-				return responseArr{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    409,
-						Message: "Username already exists. Please use a different username address.",
-					},
-				}
-			}
-		}
+
 		return responseArr{
 			Status: "failed",
 			Data:   nil,
+			Meta:   nil,
 			Errors: &httperrors.Errors{
 				Code:    500,
 				Message: httperrors.C500,
 			},
 		}
 	}
+
+	meta := &cursorMeta{Limit: q.Limit}
+	if len(products) > q.Limit {
+		products = products[:q.Limit]
+		next := q.nextCursor(products[len(products)-1])
+		meta.NextCursor = &next
+	}
 	return responseArr{
 		Status: "success",
-		Data:   product,
+		Data:   products,
+		Meta:   meta,
 		Errors: nil,
 	}
 
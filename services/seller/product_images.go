@@ -0,0 +1,312 @@
+package seller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mime/multipart"
+	"time"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/media"
+	"github.com/dikletscode/isyana-store/pkg/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const MaxProductImages = 10
+
+var (
+	errTooManyImages = errors.New("a product can have at most 10 images")
+	errImageOrder    = errors.New("image_ids must list every image of the product exactly once")
+	errImageNotFound = errors.New("Image not found")
+)
+
+// blobStore holds uploaded images, it is set when the routes are mounted.
+var blobStore storage.BlobStore = storage.NewLocalStore("")
+
+type productImage struct {
+	Id           string    `json:"id"`
+	Key          string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	ContentType  string    `json:"content_type"`
+	Size         int       `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Position     int       `json:"position"`
+	CreatedAt    time.Time `json:"created_at"`
+	URL          string    `json:"url" db:"-"`
+	ThumbnailURL string    `json:"thumbnail_url" db:"-"`
+}
+
+type imageOrderRequest struct {
+	ImageIds []string `json:"image_ids"`
+}
+
+type responseImages struct {
+	Status string             `json:"status"`
+	Data   []productImage     `json:"data"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+// querier is satisfied by both the pool and a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// loadProductImages returns the images of a product in display order.
+func loadProductImages(ctx context.Context, q querier, productId string) ([]productImage, error) {
+	rows, err := q.Query(ctx, `SELECT id::text, key, thumbnail_key, content_type, size, width, height, position, created_at
+	FROM product_images WHERE product_id = $1 ORDER BY position`, productId)
+	if err != nil {
+		return nil, err
+	}
+	images, err := pgx.CollectRows(rows, pgx.RowToStructByPos[productImage])
+	for i := range images {
+		images[i].URL = media.URL(images[i].Key)
+		images[i].ThumbnailURL = media.URL(images[i].ThumbnailKey)
+	}
+	return images, err
+}
+
+func imagesFailed(code int, message string) responseImages {
+	return responseImages{
+		Status: "failed",
+		Data:   nil,
+		Errors: &httperrors.Errors{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+// uploadProductImages appends the files to the images of the product. Every
+// file is checked before anything is stored, so one bad file rejects the batch.
+func uploadProductImages(sellerId string, productId string, files []*multipart.FileHeader) responseImages {
+	ctx := context.Background()
+	owns, err := ownsProduct(ctx, sellerId, productId)
+	if err != nil {
+		log.Println(err.Error())
+		return imagesFailed(500, httperrors.C500)
+	}
+	if !owns {
+		return responseImages{
+			Status: "failed",
+			Data:   nil,
+			Errors: productNotFound(),
+		}
+	}
+
+	images := make([]*media.Image, 0, len(files))
+	for _, file := range files {
+		img, err := media.ProcessFile(file)
+		if code := media.StatusCode(err); code != 0 {
+			return imagesFailed(code, file.Filename+": "+err.Error())
+		}
+		if err != nil {
+			log.Println(err.Error())
+			return imagesFailed(500, httperrors.C500)
+		}
+		images = append(images, img)
+	}
+
+	var stored []string
+	var result []productImage
+	err = pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		// the product row lock keeps concurrent uploads from taking the same positions
+		var count int
+		err := tx.QueryRow(ctx, `SELECT (SELECT count(*) FROM product_images WHERE product_id = p.id)
+		FROM products p WHERE p.id = $1 FOR UPDATE`, productId).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count+len(images) > MaxProductImages {
+			return errTooManyImages
+		}
+
+		for i, img := range images {
+			id := uuid.New().String()
+			key, thumbKey, err := media.Save(ctx, blobStore, "products/"+productId, id, img)
+			if err != nil {
+				return err
+			}
+			stored = append(stored, key, thumbKey)
+
+			query := `INSERT INTO product_images (id, product_id, key, thumbnail_key, content_type, size, width, height, position)
+			VALUES (@id, @productId, @key, @thumbnailKey, @contentType, @size, @width, @height, @position)`
+			args := pgx.NamedArgs{
+				"id":           id,
+				"productId":    productId,
+				"key":          key,
+				"thumbnailKey": thumbKey,
+				"contentType":  img.ContentType,
+				"size":         len(img.Data),
+				"width":        img.Width,
+				"height":       img.Height,
+				"position":     count + i,
+			}
+			if _, err = tx.Exec(ctx, query, args); err != nil {
+				return err
+			}
+		}
+
+		result, err = loadProductImages(ctx, tx, productId)
+		return err
+	})
+
+	if err != nil {
+		for _, key := range stored {
+			if err := blobStore.Delete(ctx, key); err != nil {
+				log.Println(err.Error())
+			}
+		}
+		if err == errTooManyImages {
+			return imagesFailed(422, err.Error())
+		}
+		log.Println(err.Error())
+		return imagesFailed(500, httperrors.C500)
+	}
+
+	return responseImages{
+		Status: "success",
+		Data:   result,
+		Errors: nil,
+	}
+}
+
+func getProductImages(productId string) responseImages {
+	images, err := loadProductImages(context.Background(), db.PG, productId)
+	if err != nil {
+		log.Println(err.Error())
+		return imagesFailed(500, httperrors.C500)
+	}
+	return responseImages{
+		Status: "success",
+		Data:   images,
+		Errors: nil,
+	}
+}
+
+// reorderProductImages sets the display order, image_ids is the full list of
+// the product's images with the cover first.
+func reorderProductImages(sellerId string, productId string, req imageOrderRequest) responseImages {
+	ctx := context.Background()
+	owns, err := ownsProduct(ctx, sellerId, productId)
+	if err == nil && !owns {
+		return responseImages{
+			Status: "failed",
+			Data:   nil,
+			Errors: productNotFound(),
+		}
+	}
+
+	var result []productImage
+	if err == nil {
+		err = pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, `SELECT id FROM products WHERE id = $1 FOR UPDATE`, productId); err != nil {
+				return err
+			}
+			current, err := loadProductImages(ctx, tx, productId)
+			if err != nil {
+				return err
+			}
+
+			known := map[string]bool{}
+			for _, img := range current {
+				known[img.Id] = true
+			}
+			if len(req.ImageIds) != len(current) {
+				return errImageOrder
+			}
+			for _, id := range req.ImageIds {
+				if !known[id] {
+					return errImageOrder
+				}
+				delete(known, id)
+			}
+
+			_, err = tx.Exec(ctx, `UPDATE product_images i SET position = x.position - 1
+			FROM unnest($2::uuid[]) WITH ORDINALITY AS x(id, position)
+			WHERE i.product_id = $1 AND i.id = x.id`, productId, req.ImageIds)
+			if err != nil {
+				return err
+			}
+			result, err = loadProductImages(ctx, tx, productId)
+			return err
+		})
+	}
+
+	if err == errImageOrder {
+		return imagesFailed(400, "Bad Request: "+err.Error())
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return imagesFailed(500, httperrors.C500)
+	}
+	return responseImages{
+		Status: "success",
+		Data:   result,
+		Errors: nil,
+	}
+}
+
+// deleteProductImage removes an image and closes the gap it leaves in the order.
+// The blobs go once the rows are gone, a failure there only leaves an orphan file.
+func deleteProductImage(sellerId string, productId string, imageId string) responseImages {
+	ctx := context.Background()
+	owns, err := ownsProduct(ctx, sellerId, productId)
+	if err == nil && !owns {
+		return responseImages{
+			Status: "failed",
+			Data:   nil,
+			Errors: productNotFound(),
+		}
+	}
+
+	var keys [2]string
+	var result []productImage
+	if err == nil {
+		err = pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, `SELECT id FROM products WHERE id = $1 FOR UPDATE`, productId); err != nil {
+				return err
+			}
+			var position int
+			err := tx.QueryRow(ctx, `DELETE FROM product_images WHERE id = $1 AND product_id = $2
+			RETURNING key, thumbnail_key, position`, imageId, productId).Scan(&keys[0], &keys[1], &position)
+			if err == pgx.ErrNoRows {
+				return errImageNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(ctx, `UPDATE product_images SET position = position - 1
+			WHERE product_id = $1 AND position > $2`, productId, position)
+			if err != nil {
+				return err
+			}
+			result, err = loadProductImages(ctx, tx, productId)
+			return err
+		})
+	}
+
+	if err == errImageNotFound {
+		return imagesFailed(404, err.Error())
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return imagesFailed(500, httperrors.C500)
+	}
+
+	for _, key := range keys {
+		if err := blobStore.Delete(ctx, key); err != nil {
+			log.Println(err.Error())
+		}
+	}
+	return responseImages{
+		Status: "success",
+		Data:   result,
+		Errors: nil,
+	}
+}
@@ -0,0 +1,523 @@
+package seller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	MaxImportRows  = 5000
+	MaxImportBytes = 10 << 20
+)
+
+// Formats of the import and the export.
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// importColumns is the header of an import and of an export, in export order.
+var importColumns = []string{"sku", "name", "description", "price", "stock", "category_id"}
+
+var (
+	errImportFormat  = errors.New("send text/csv or application/x-ndjson, or pick one with ?format=csv or ?format=ndjson")
+	errImportEmpty   = errors.New("the file has no rows")
+	errImportTooMany = fmt.Errorf("an import can have at most %d rows", MaxImportRows)
+	errImportBusy    = errors.New("a sku of the import was taken meanwhile, try again")
+	// errDryRun rolls back a dry run once the report is complete
+	errDryRun = errors.New("dry run")
+)
+
+// importRow is one line of an import. The sku names the variant, rows with a
+// new sku become single variant products, known skus update their product and
+// set the stock of their variant.
+type importRow struct {
+	Line        int     `json:"-"`
+	Sku         string  `json:"sku"`
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Price       int     `json:"price"`
+	Stock       int     `json:"stock"`
+	CategoryId  *string `json:"category_id"`
+}
+
+type importRowError struct {
+	Line    int    `json:"line"`
+	Sku     string `json:"sku"`
+	Message string `json:"message"`
+}
+
+type importReport struct {
+	DryRun  bool             `json:"dry_run"`
+	Rows    int              `json:"rows"`
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Failed  int              `json:"failed"`
+	Errors  []importRowError `json:"errors"`
+}
+
+type responseImport struct {
+	Status string             `json:"status"`
+	Data   *importReport      `json:"data"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+// importFormat picks the format from ?format, or else from the Content-Type.
+func importFormat(format string, contentType string) (string, error) {
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		switch mediaType {
+		case "text/csv":
+			format = formatCSV
+		case "application/x-ndjson", "application/jsonl":
+			format = formatNDJSON
+		}
+	}
+	if format != formatCSV && format != formatNDJSON {
+		return "", errImportFormat
+	}
+	return format, nil
+}
+
+// readCSVRows reads a CSV with a header row naming importColumns, in any order.
+// Rows that don't parse are reported, a broken file fails as a whole.
+func readCSVRows(r io.Reader) ([]importRow, []importRowError, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errImportEmpty
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF")))
+		known := false
+		for _, column := range importColumns {
+			known = known || column == name
+		}
+		if _, dup := columns[name]; !known || dup {
+			return nil, nil, fmt.Errorf("unknown or repeated column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, required := range []string{"sku", "name", "price", "stock"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, fmt.Errorf("column %q is missing", required)
+		}
+	}
+
+	var rows []importRow
+	var rowErrors []importRowError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if len(rows)+len(rowErrors) >= MaxImportRows {
+			return nil, nil, errImportTooMany
+		}
+		// the reader skips past a line it could not parse, e.g. a stray quote,
+		// so the rest of the file is still read
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			message := parseErr.Err.Error()
+			if errors.Is(err, csv.ErrFieldCount) {
+				message = fmt.Sprintf("expected %d fields", len(header))
+			}
+			rowErrors = append(rowErrors, importRowError{Line: parseErr.Line, Message: message})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		row := importRow{Line: line, Sku: field("sku"), Name: field("name")}
+		description := field("description")
+		row.Description = &description
+		if categoryId := field("category_id"); categoryId != "" {
+			row.CategoryId = &categoryId
+		}
+		var priceErr, stockErr error
+		row.Price, priceErr = strconv.Atoi(field("price"))
+		row.Stock, stockErr = strconv.Atoi(field("stock"))
+		if priceErr != nil || stockErr != nil {
+			rowErrors = append(rowErrors, importRowError{Line: line, Sku: row.Sku, Message: "price and stock must be whole numbers"})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, nil
+}
+
+// readNDJSONRows reads one JSON object per line, blank lines are skipped.
+func readNDJSONRows(r io.Reader) ([]importRow, []importRowError, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+
+	var rows []importRow
+	var rowErrors []importRowError
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if len(rows)+len(rowErrors) >= MaxImportRows {
+			return nil, nil, errImportTooMany
+		}
+
+		row := importRow{Line: line}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&row); err != nil {
+			rowErrors = append(rowErrors, importRowError{Line: line, Message: "invalid JSON: " + err.Error()})
+			continue
+		}
+		if row.Description == nil {
+			row.Description = new(string)
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(rows)+len(rowErrors) == 0 {
+		return nil, nil, errImportEmpty
+	}
+	return rows, rowErrors, nil
+}
+
+// validateImportRows applies the rules of postProduct to every row and drops
+// the rows that break them, or repeat a sku, into the report.
+func validateImportRows(rows []importRow, report *importReport) []importRow {
+	valid := make([]importRow, 0, len(rows))
+	firstLine := map[string]int{}
+	for _, row := range rows {
+		err := validateProduct(product{Name: row.Name, Description: row.Description, Price: row.Price, Stock: row.Stock, CategoryId: row.CategoryId})
+		if !skuPattern.MatchString(row.Sku) {
+			err = errors.New("sku must be 1 to 64 letters, digits, dots, dashes or underscores")
+		} else if line, dup := firstLine[row.Sku]; dup {
+			err = fmt.Errorf("sku already used on line %d", line)
+		} else {
+			firstLine[row.Sku] = row.Line
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, importRowError{Line: row.Line, Sku: row.Sku, Message: err.Error()})
+			continue
+		}
+		valid = append(valid, row)
+	}
+	return valid
+}
+
+// importProducts copies the rows into a staging table and upserts them by sku
+// from there. Rows the database rejects, an unknown category or a stock below
+// what checkouts hold, are reported and left out, the others are applied.
+func importProducts(ctx context.Context, sellerId string, rows []importRow, report *importReport) error {
+	return pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('import:' || $1))`, sellerId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `CREATE TEMP TABLE product_import (
+			line INTEGER PRIMARY KEY,
+			sku VARCHAR(64) NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			description VARCHAR(200),
+			price INTEGER NOT NULL,
+			stock INTEGER NOT NULL,
+			category_id UUID,
+			product_id UUID,
+			variant_id UUID,
+			created BOOLEAN NOT NULL DEFAULT false
+		) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CopyFrom(
+			ctx,
+			pgx.Identifier{"product_import"},
+			[]string{"line", "sku", "name", "description", "price", "stock", "category_id"},
+			pgx.CopyFromSlice(len(rows), func(i int) ([]any, error) {
+				row := rows[i]
+				return []any{row.Line, row.Sku, row.Name, row.Description, row.Price, row.Stock, row.CategoryId}, nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+
+		// the product locks keep reservations still while the rows are checked
+		_, err = tx.Exec(ctx, `UPDATE product_import s SET product_id = v.product_id, variant_id = v.id
+		FROM product_variants v WHERE v.seller_id = $1 AND v.sku = s.sku AND v.deleted_at IS NULL`, sellerId)
+		if err == nil {
+			_, err = tx.Exec(ctx, `SELECT id FROM products WHERE id IN (SELECT product_id FROM product_import) ORDER BY id FOR UPDATE`)
+		}
+		if err != nil {
+			return err
+		}
+
+		failed, err := checkImportRows(ctx, tx, report)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `DELETE FROM product_import WHERE line = ANY($1)`, failed)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(ctx, `WITH fresh AS (
+			UPDATE product_import SET product_id = gen_random_uuid(), variant_id = gen_random_uuid(), created = true
+			WHERE variant_id IS NULL RETURNING line
+		)
+		SELECT (SELECT count(*) FROM fresh), (SELECT count(*) FROM product_import) - (SELECT count(*) FROM fresh)`).Scan(&report.Created, &report.Updated)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE products p SET name = s.name, description = s.description, price = s.price,
+		category_id = s.category_id, updated_at = now()
+		FROM product_import s WHERE p.id = s.product_id AND NOT s.created`)
+		if err == nil {
+			_, err = tx.Exec(ctx, `INSERT INTO products (id, name, description, price, stock, category_id, seller_id)
+			SELECT product_id, name, description, price, 0, category_id, $1 FROM product_import WHERE created`, sellerId)
+		}
+		if err == nil {
+			_, err = tx.Exec(ctx, `INSERT INTO product_variants (id, product_id, seller_id, sku, stock)
+			SELECT variant_id, product_id, $1, sku, 0 FROM product_import WHERE created`, sellerId)
+		}
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.ConstraintName == "product_variants_sku_key" {
+				return errImportBusy
+			}
+			return err
+		}
+
+		if err = applyImportStock(ctx, tx, sellerId); err != nil {
+			return err
+		}
+		if report.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+}
+
+// checkImportRows reports the staged rows the database would reject and
+// returns their lines.
+func checkImportRows(ctx context.Context, tx pgx.Tx, report *importReport) ([]int, error) {
+	rows, err := tx.Query(ctx, `SELECT s.line, s.sku,
+	s.category_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM categories c WHERE c.id = s.category_id),
+	p.deleted_at IS NOT NULL, s.stock < COALESCE(a.reserved, 0),
+	EXISTS (SELECT 1 FROM product_import o WHERE o.product_id = s.product_id
+		AND (o.name, o.description, o.price, o.category_id) IS DISTINCT FROM (s.name, s.description, s.price, s.category_id))
+	FROM product_import s
+	LEFT JOIN products p ON p.id = s.product_id
+	LEFT JOIN variant_availability a ON a.variant_id = s.variant_id
+	ORDER BY s.line`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failed := []int{}
+	for rows.Next() {
+		var line int
+		var sku string
+		var unknownCategory, deleted, belowReserved, disagree bool
+		if err = rows.Scan(&line, &sku, &unknownCategory, &deleted, &belowReserved, &disagree); err != nil {
+			return nil, err
+		}
+
+		message := ""
+		switch {
+		case unknownCategory:
+			message = "unknown category_id"
+		case deleted:
+			message = "the sku belongs to a deleted product, restore it first"
+		case belowReserved:
+			message = errStockBelowReserved.Error()
+		case disagree:
+			message = "rows for variants of one product must agree on name, description, price and category_id"
+		}
+		if message != "" {
+			report.Errors = append(report.Errors, importRowError{Line: line, Sku: sku, Message: message})
+			failed = append(failed, line)
+		}
+	}
+	return failed, rows.Err()
+}
+
+// applyImportStock books the stock of the staged rows, a restock for new
+// variants and an adjustment for known ones.
+func applyImportStock(ctx context.Context, tx pgx.Tx, sellerId string) error {
+	rows, err := tx.Query(ctx, `SELECT s.product_id::text, s.variant_id::text, s.stock - v.stock, s.created
+	FROM product_import s JOIN product_variants v ON v.id = s.variant_id
+	WHERE s.stock <> v.stock`)
+	if err != nil {
+		return err
+	}
+	note := "import"
+	var movements []StockMovement
+	for rows.Next() {
+		m := StockMovement{Reason: MovementAdjustment, ActorId: &sellerId, Note: &note}
+		var created bool
+		if err = rows.Scan(&m.ProductId, &m.VariantId, &m.Delta, &created); err != nil {
+			rows.Close()
+			return err
+		}
+		if created {
+			m.Reason = MovementRestock
+		}
+		movements = append(movements, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return ApplyStockMovements(ctx, tx, movements...)
+}
+
+func importFailed(code int, message string) responseImport {
+	return responseImport{
+		Status: "failed",
+		Data:   nil,
+		Errors: &httperrors.Errors{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+// postImport reads an import in the given format and applies it. With dryRun
+// everything runs, the database checks included, and is rolled back, so the
+// report is what a real run would give.
+func postImport(sellerId string, format string, body io.Reader, dryRun bool) responseImport {
+	var rows []importRow
+	var rowErrors []importRowError
+	var err error
+	if format == formatCSV {
+		rows, rowErrors, err = readCSVRows(body)
+	} else {
+		rows, rowErrors, err = readNDJSONRows(body)
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return importFailed(413, "the file is larger than 10 MiB")
+	case err == errImportTooMany:
+		return importFailed(422, err.Error())
+	case err != nil:
+		return importFailed(400, "Bad Request: "+err.Error())
+	}
+
+	report := importReport{DryRun: dryRun, Rows: len(rows) + len(rowErrors), Errors: rowErrors}
+	valid := validateImportRows(rows, &report)
+	if len(valid) > 0 {
+		err = importProducts(context.Background(), sellerId, valid, &report)
+	}
+	if err == errImportBusy {
+		return importFailed(409, err.Error())
+	}
+	if err != nil && err != errDryRun {
+		log.Println(err.Error())
+		return importFailed(500, httperrors.C500)
+	}
+
+	if report.Errors == nil {
+		report.Errors = []importRowError{}
+	}
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+	report.Failed = len(report.Errors)
+	return responseImport{
+		Status: "success",
+		Data:   &report,
+		Errors: nil,
+	}
+}
+
+// exportProducts streams the seller's catalog, one row per variant, in a
+// format postImport reads back. Variants without a sku come out with an empty
+// one and need a sku before they can be imported.
+func exportProducts(ctx context.Context, w io.Writer, sellerId string, format string) error {
+	rows, err := db.PG.Query(ctx, `SELECT COALESCE(v.sku, ''), p.name, p.description, p.price::INTEGER, v.stock, p.category_id::text
+	FROM products p JOIN product_variants v ON v.product_id = p.id
+	WHERE p.seller_id = $1 AND p.deleted_at IS NULL AND v.deleted_at IS NULL
+	ORDER BY p.created_at, p.id, v.created_at, v.id`, sellerId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	flusher, _ := w.(http.Flusher)
+	writer := csv.NewWriter(w)
+	encoder := json.NewEncoder(w)
+	if format == formatCSV {
+		if err = writer.Write(importColumns); err != nil {
+			return err
+		}
+	}
+
+	for n := 1; rows.Next(); n++ {
+		var row importRow
+		if err = rows.Scan(&row.Sku, &row.Name, &row.Description, &row.Price, &row.Stock, &row.CategoryId); err != nil {
+			return err
+		}
+		if format == formatNDJSON {
+			err = encoder.Encode(row)
+		} else {
+			description, categoryId := "", ""
+			if row.Description != nil {
+				description = *row.Description
+			}
+			if row.CategoryId != nil {
+				categoryId = *row.CategoryId
+			}
+			err = writer.Write([]string{row.Sku, row.Name, description, strconv.Itoa(row.Price), strconv.Itoa(row.Stock), categoryId})
+		}
+		if err != nil {
+			return err
+		}
+
+		if n%500 == 0 {
+			writer.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	writer.Flush()
+	if err = writer.Error(); err != nil {
+		return err
+	}
+	return rows.Err()
+}
@@ -0,0 +1,66 @@
+package seller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCSVRows(t *testing.T) {
+	const header = "sku,name,price,stock\n"
+
+	tests := []struct {
+		name       string
+		csv        string
+		wantLines  []int
+		wantErrors []importRowError
+	}{
+		{
+			name:      "clean file",
+			csv:       header + "a-1,Mug,2500,3\nb-1,Cup,1500,0\n",
+			wantLines: []int{2, 3},
+		},
+		{
+			name:       "stray quote",
+			csv:        header + "a\"b,c,1,2\nb-1,Cup,1500,0\n",
+			wantLines:  []int{3},
+			wantErrors: []importRowError{{Line: 2, Message: `bare " in non-quoted-field`}},
+		},
+		{
+			name:       "wrong field count",
+			csv:        header + "a-1,Mug,2500\nb-1,Cup,1500,0\n",
+			wantLines:  []int{3},
+			wantErrors: []importRowError{{Line: 2, Message: "expected 4 fields"}},
+		},
+		{
+			name:       "unterminated quote ends the file",
+			csv:        header + "a-1,Mug,2500,3\n\"b-1,Cup,1500,0\n",
+			wantLines:  []int{2},
+			wantErrors: []importRowError{{Line: 3, Message: `extraneous or missing " in quoted-field`}},
+		},
+		{
+			name:       "numbers that aren't",
+			csv:        header + "a-1,Mug,cheap,3\n",
+			wantErrors: []importRowError{{Line: 2, Sku: "a-1", Message: "price and stock must be whole numbers"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, rowErrors, err := readCSVRows(strings.NewReader(tt.csv))
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			var lines []int
+			for _, row := range rows {
+				lines = append(lines, row.Line)
+			}
+			if !reflect.DeepEqual(lines, tt.wantLines) {
+				t.Errorf("row lines = %v, want %v", lines, tt.wantLines)
+			}
+			if !reflect.DeepEqual(rowErrors, tt.wantErrors) {
+				t.Errorf("row errors = %+v, want %+v", rowErrors, tt.wantErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package seller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultProductLimit = 20
+	maxProductLimit     = 100
+)
+
+// productColumns matches the field order of product for RowToStructByPos.
+const productColumns = `p.id::text, p.name, p.description, p.price::INTEGER, p.stock, a.available,
+	p.rating_count, ROUND(p.rating_total::NUMERIC / NULLIF(p.rating_count, 0), 2)::FLOAT8 AS rating_average,
+	p.category_id, p.seller_id::text, p.created_at, p.updated_at, p.deleted_at`
+
+const productFrom = `products p JOIN product_availability a ON a.product_id = p.id`
+
+// categoryCondition filters on @categoryId, or on it and every category nested
+// below it.
+func categoryCondition(includeDescendants bool) string {
+	if !includeDescendants {
+		return "p.category_id = @categoryId"
+	}
+	return `p.category_id IN (
+		WITH RECURSIVE tree AS (
+			SELECT id FROM categories WHERE id = @categoryId
+			UNION ALL
+			SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id
+		)
+		SELECT id FROM tree
+	)`
+}
+
+// parseIncludeDescendants reads ?include_descendants=, false when absent.
+func parseIncludeDescendants(query url.Values) (bool, error) {
+	value := query.Get("include_descendants")
+	if value == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("include_descendants must be true or false")
+	}
+	return include, nil
+}
+
+// productSorts maps the sort keys of GET /product to their column and the type
+// a cursor value is cast back to.
+var productSorts = map[string][2]string{
+	"price":      {"p.price", "numeric"},
+	"created_at": {"p.created_at", "timestamptz"},
+	"name":       {"p.name", "text"},
+}
+
+type cursorMeta struct {
+	Limit      int     `json:"limit"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// productCursor points just past the last product of a page. It remembers the
+// sort it was made for so it can't be replayed against another ordering.
+type productCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Id    string `json:"id"`
+}
+
+func (c productCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeProductCursor(value string) (*productCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var c productCursor
+	if err = json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+	if _, err = uuid.Parse(c.Id); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+type productQuery struct {
+	CategoryId string
+	// IncludeDescendants widens CategoryId to its child categories
+	IncludeDescendants bool
+	SellerId           string
+	MinPrice           *int
+	MaxPrice           *int
+	InStock            bool
+	Sort               string // "price", "-price", ...
+	Limit              int
+	Cursor             *productCursor
+}
+
+func parseProductQuery(query url.Values) (productQuery, error) {
+	q := productQuery{
+		CategoryId: query.Get("category_id"),
+		SellerId:   query.Get("seller_id"),
+		Sort:       query.Get("sort"),
+		Limit:      defaultProductLimit,
+	}
+
+	if q.Sort == "" {
+		q.Sort = "-created_at"
+	}
+	if _, ok := productSorts[strings.TrimPrefix(q.Sort, "-")]; !ok {
+		return q, errors.New("sort must be one of price, created_at, name, optionally prefixed with -")
+	}
+
+	if q.CategoryId != "" {
+		if _, err := uuid.Parse(q.CategoryId); err != nil {
+			return q, errors.New("Invalid category id")
+		}
+	}
+	include, err := parseIncludeDescendants(query)
+	if err != nil {
+		return q, err
+	}
+	q.IncludeDescendants = include
+
+	if q.SellerId != "" {
+		if _, err := uuid.Parse(q.SellerId); err != nil {
+			return q, errors.New("Invalid seller id")
+		}
+	}
+
+	for name, target := range map[string]**int{"min_price": &q.MinPrice, "max_price": &q.MaxPrice} {
+		if value := query.Get(name); value != "" {
+			price, err := strconv.Atoi(value)
+			if err != nil || price < 0 {
+				return q, errors.New(name + " must be a positive number")
+			}
+			*target = &price
+		}
+	}
+	if q.MinPrice != nil && q.MaxPrice != nil && *q.MinPrice > *q.MaxPrice {
+		return q, errors.New("min_price must not be above max_price")
+	}
+
+	if value := query.Get("in_stock"); value != "" {
+		inStock, err := strconv.ParseBool(value)
+		if err != nil {
+			return q, errors.New("in_stock must be true or false")
+		}
+		q.InStock = inStock
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxProductLimit {
+			return q, errors.New("limit must be between 1 and 100")
+		}
+		q.Limit = limit
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeProductCursor(value)
+		if err != nil || cursor.Sort != q.Sort {
+			return q, errors.New("cursor is invalid for this sort")
+		}
+		q.Cursor = cursor
+	}
+
+	return q, nil
+}
+
+// sql builds the listing query. It asks for one row more than the limit to
+// know whether there is a next page.
+func (q productQuery) sql() (string, pgx.NamedArgs) {
+	key := strings.TrimPrefix(q.Sort, "-")
+	column, cast := productSorts[key][0], productSorts[key][1]
+	direction, compare := "ASC", ">"
+	if strings.HasPrefix(q.Sort, "-") {
+		direction, compare = "DESC", "<"
+	}
+
+	where := []string{"p.deleted_at IS NULL"}
+	args := pgx.NamedArgs{"limit": q.Limit + 1}
+
+	if q.CategoryId != "" {
+		where = append(where, categoryCondition(q.IncludeDescendants))
+		args["categoryId"] = q.CategoryId
+	}
+	if q.SellerId != "" {
+		where = append(where, "p.seller_id = @sellerId")
+		args["sellerId"] = q.SellerId
+	}
+	if q.MinPrice != nil {
+		where = append(where, "p.price >= @minPrice")
+		args["minPrice"] = *q.MinPrice
+	}
+	if q.MaxPrice != nil {
+		where = append(where, "p.price <= @maxPrice")
+		args["maxPrice"] = *q.MaxPrice
+	}
+	if q.InStock {
+		where = append(where, "a.available > 0")
+	}
+	if q.Cursor != nil {
+		where = append(where, "("+column+", p.id) "+compare+" (@cursorValue::"+cast+", @cursorId::uuid)")
+		args["cursorValue"] = q.Cursor.Value
+		args["cursorId"] = q.Cursor.Id
+	}
+
+	query := `SELECT ` + productColumns + ` FROM ` + productFrom + `
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY ` + column + ` ` + direction + `, p.id ` + direction + `
+	LIMIT @limit`
+	return query, args
+}
+
+// nextCursor points past the last product of the page.
+func (q productQuery) nextCursor(last product) string {
+	c := productCursor{Sort: q.Sort, Id: last.Id}
+	switch strings.TrimPrefix(q.Sort, "-") {
+	case "price":
+		c.Value = strconv.Itoa(last.Price)
+	case "created_at":
+		c.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	case "name":
+		c.Value = last.Name
+	}
+	return c.encode()
+}
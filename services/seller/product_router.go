@@ -2,152 +2,457 @@ package seller
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
-	"strings"
+	"strconv"
 
 	"github.com/dikletscode/isyana-store/middleware"
 	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/media"
+	"github.com/dikletscode/isyana-store/pkg/router"
+	"github.com/dikletscode/isyana-store/pkg/storage"
 )
 
-func SellerRouter() {
-	http.Handle("/product", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func SellerRouter(rt *router.Router, store storage.BlobStore) {
+	blobStore = store
+	products := rt.Group("/product")
+	sellerOnly := []router.Middleware{middleware.AuthMiddleware, middleware.RequireRole(middleware.RoleSeller)}
+
+	products.Post("", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
-		if r.Method == http.MethodPost {
-
-			claims := middleware.UserFromContext(r.Context())
-
-			jwtUserID, ok := claims["jti"].(string)
-
-			decoder := json.NewDecoder(r.Body)
-			var incomingProduct product
-			var resp response
-			err := decoder.Decode(&incomingProduct)
-			if !ok || err != nil {
-				// Handle the case where "jti" is not a string
-				resp = response{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid input data",
-					},
-				}
-			}
-			resp = postProduct(jwtUserID, incomingProduct)
 
-			if resp.Status == "success" {
-				w.WriteHeader(http.StatusCreated)
-			} else {
-				w.WriteHeader(resp.Errors.Code)
-			}
-			err = json.NewEncoder(w).Encode(resp)
-			if err != nil {
-				http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, ok := claims["jti"].(string)
+
+		decoder := json.NewDecoder(r.Body)
+		var incomingProduct product
+		var resp response
+		err := decoder.Decode(&incomingProduct)
+		if !ok || err != nil {
+			// Handle the case where "jti" is not a string
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
 			}
+		}
+		resp = postProduct(jwtUserID, incomingProduct)
 
-		} else if r.Method == http.MethodGet {
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
 
-			categoryId := r.URL.Query().Get("category_id")
+	}, sellerOnly...)
 
-			resp := getProducts(categoryId)
+	products.Get("", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
+		w.Header().Set("Content-Type", "application/json")
 
-			if resp.Status == "success" {
-				w.WriteHeader(http.StatusOK)
-			} else {
-				w.WriteHeader(resp.Errors.Code)
-			}
-			err := json.NewEncoder(w).Encode(resp)
-			if err != nil {
-				http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
-			}
+		resp := getProducts(r.URL.Query())
 
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
 		} else {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
 		}
 
-	}), []string{"GET"}))
-
-	http.Handle("/product/", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPut {
-			var resp response
-			breakUrl := strings.Split(r.URL.Path, "/")
-			if len(breakUrl) <= 2 {
-				resp = response{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid URL format",
-					},
-				}
-			}
+	})
 
-			claims := middleware.UserFromContext(r.Context())
-
-			jwtUserID, ok := claims["jti"].(string)
-
-			decoder := json.NewDecoder(r.Body)
-			var incomingProduct product
-			incomingProduct.Id = breakUrl[len(breakUrl)-1]
-
-			err := decoder.Decode(&incomingProduct)
-			if !ok || err != nil {
-				// Handle the case where "jti" is not a string
-				resp = response{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid input data",
-					},
-				}
-			}
-			resp = updateProduct(jwtUserID, incomingProduct)
+	products.Get("/search", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
+		w.Header().Set("Content-Type", "application/json")
+
+		resp := searchProducts(r.URL.Query())
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+
+	})
+
+	products.Put("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+		var resp response
+
+		claims := middleware.UserFromContext(r.Context())
 
-			if resp.Status == "success" {
-				w.WriteHeader(http.StatusOK)
-			} else {
-				w.WriteHeader(resp.Errors.Code)
+		jwtUserID, ok := claims["jti"].(string)
+
+		decoder := json.NewDecoder(r.Body)
+		var incomingProduct product
+
+		err := decoder.Decode(&incomingProduct)
+		incomingProduct.Id = router.Param(r, "id")
+		if !ok || err != nil {
+			// Handle the case where "jti" is not a string
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
 			}
-			err = json.NewEncoder(w).Encode(resp)
-			if err != nil {
-				http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+		resp = updateProduct(jwtUserID, incomingProduct)
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+
+	}, sellerOnly...)
+
+	products.Get("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+
+		resp := getProductById(router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, middleware.AuthMiddleware)
+
+	products.Get("/{id:uuid}/inventory", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		resp := getInventoryMovements(jwtUserID, router.Param(r, "id"), r.URL.Query())
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	products.Get("/{id:uuid}/inventory/check", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		resp := checkInventory(jwtUserID, router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	products.Delete("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		resp := deleteProduct(jwtUserID, router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	products.Post("/{id:uuid}/restore", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		resp := restoreProduct(jwtUserID, router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	products.Post("/{id:uuid}/images", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		var resp responseImages
+		files, err := media.Files(w, r, "images", MaxProductImages)
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
+		}
+		if code := media.StatusCode(err); code != 0 {
+			resp = imagesFailed(code, err.Error())
+		} else if err != nil {
+			resp = imagesFailed(400, "Bad Request: expected a multipart form with images")
+		} else {
+			resp = uploadProductImages(jwtUserID, router.Param(r, "id"), files)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	products.Get("/{id:uuid}/images", func(w http.ResponseWriter, r *http.Request) {
+
+		resp := getProductImages(router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
+
+	products.Put("/{id:uuid}/images/order", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		var req imageOrderRequest
+		var resp responseImages
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			resp = imagesFailed(400, "Bad Request: Invalid input data")
+		} else {
+			resp = reorderProductImages(jwtUserID, router.Param(r, "id"), req)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	products.Delete("/{id:uuid}/images/{imageId:uuid}", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		resp := deleteProductImage(jwtUserID, router.Param(r, "id"), router.Param(r, "imageId"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	products.Post("/{id:uuid}/variants", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		var incomingVariant variant
+		var resp response
+		err := json.NewDecoder(r.Body).Decode(&incomingVariant)
+		if err != nil {
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
 			}
+		} else {
+			resp = addVariant(jwtUserID, router.Param(r, "id"), incomingVariant)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	products.Put("/{id:uuid}/variants/{variantId:uuid}", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
 
-		} else if r.Method == http.MethodGet {
-
-			var resp response
-			breakUrl := strings.Split(r.URL.Path, "/")
-			if len(breakUrl) <= 2 {
-				resp = response{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid URL format",
-					},
-				}
+		jwtUserID, _ := claims["jti"].(string)
+
+		var req variantUpdate
+		var resp response
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
 			}
+		} else {
+			resp = updateVariant(jwtUserID, router.Param(r, "id"), router.Param(r, "variantId"), req)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	products.Delete("/{id:uuid}/variants/{variantId:uuid}", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
 
-			id := breakUrl[len(breakUrl)-1]
+		jwtUserID, _ := claims["jti"].(string)
 
-			resp = getProductById(id)
+		resp := deleteVariant(jwtUserID, router.Param(r, "id"), router.Param(r, "variantId"))
 
-			if resp.Status == "success" {
-				w.WriteHeader(http.StatusOK)
-			} else {
-				w.WriteHeader(resp.Errors.Code)
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	products.Post("/import", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		var resp responseImport
+		query := r.URL.Query()
+		format, err := importFormat(query.Get("format"), r.Header.Get("Content-Type"))
+		dryRun := false
+		if err != nil {
+			resp = importFailed(http.StatusUnsupportedMediaType, err.Error())
+		} else if value := query.Get("dry_run"); value != "" {
+			if dryRun, err = strconv.ParseBool(value); err != nil {
+				resp = importFailed(400, "Bad Request: dry_run must be true or false")
 			}
-			err := json.NewEncoder(w).Encode(resp)
+		}
+		if err == nil {
+			r.Body = http.MaxBytesReader(w, r.Body, MaxImportBytes)
+			resp = postImport(jwtUserID, format, r.Body, dryRun)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	products.Get("/export", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		format := r.URL.Query().Get("format")
+		switch format {
+		case "", formatCSV:
+			format = formatCSV
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		case formatNDJSON:
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			err := json.NewEncoder(w).Encode(importFailed(400, "Bad Request: format must be csv or ndjson"))
 			if err != nil {
 				http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
 			}
-		} else {
-			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
+		w.Header().Set("Content-Disposition", `attachment; filename="products.`+format+`"`)
 
-	}), nil))
+		// the status is out with the first row, a failure midway can only cut the file short
+		if err := exportProducts(r.Context(), w, jwtUserID, format); err != nil {
+			log.Println(err.Error())
+		}
+	}, sellerOnly...)
 
 }
@@ -0,0 +1,183 @@
+package seller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const maxSearchLength = 100
+
+// searchMatch selects live products whose words start with the query words, or
+// whose name is close enough to the query to forgive a typo.
+const searchMatch = `p.deleted_at IS NULL AND (p.search_vector @@ q.query OR @q <% p.name)`
+
+type searchHit struct {
+	product
+	Rank                 float64 `json:"rank"`
+	Total                int     `json:"-"`
+	NameHighlight        string  `json:"name_highlight"`
+	DescriptionHighlight *string `json:"description_highlight"`
+}
+
+type categoryFacet struct {
+	CategoryId *string `json:"category_id"`
+	Name       *string `json:"name"`
+	Count      int     `json:"count"`
+}
+
+type searchMeta struct {
+	pagination
+	Facets []categoryFacet `json:"facets"`
+}
+
+type responseSearch struct {
+	Status string             `json:"status"`
+	Data   []searchHit        `json:"data"`
+	Meta   *searchMeta        `json:"meta"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+// prefixQuery turns free text into a tsquery where every word must match the
+// start of a word, "red sho" becomes "red:* & sho:*". Anything but letters and
+// digits separates words, so the result is always a valid tsquery.
+func prefixQuery(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+func searchProducts(query url.Values) responseSearch {
+	text := strings.TrimSpace(query.Get("q"))
+	tsQuery := prefixQuery(text)
+	categoryId := query.Get("category_id")
+	page, limit := 1, defaultProductLimit
+
+	var err error
+	switch {
+	case tsQuery == "":
+		err = errors.New("q is required")
+	case len(text) > maxSearchLength:
+		err = errors.New("q must be at most 100 characters")
+	}
+	if value := query.Get("page"); err == nil && value != "" {
+		if page, err = strconv.Atoi(value); err != nil || page < 1 {
+			err = errors.New("page must be a positive number")
+		}
+	}
+	if value := query.Get("limit"); err == nil && value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxProductLimit {
+			err = errors.New("limit must be between 1 and 100")
+		}
+	}
+	if err == nil && categoryId != "" {
+		if _, err = uuid.Parse(categoryId); err != nil {
+			err = errors.New("Invalid category id")
+		}
+	}
+	includeDescendants := false
+	if err == nil {
+		includeDescendants, err = parseIncludeDescendants(query)
+	}
+	if err != nil {
+		return responseSearch{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: &httperrors.Errors{
+				Code:    400,
+				Message: "Bad Request: " + err.Error(),
+			},
+		}
+	}
+
+	args := pgx.NamedArgs{
+		"q":      text,
+		"tsq":    tsQuery,
+		"limit":  limit,
+		"offset": (page - 1) * limit,
+	}
+	filter := ""
+	if categoryId != "" {
+		filter = ` AND ` + categoryCondition(includeDescendants)
+		args["categoryId"] = categoryId
+	}
+
+	// headlines are only built for the page, the inner query ranks and cuts first
+	hitsQuery := `SELECT hit.*,
+	ts_headline('simple', hit.name, q.query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+	CASE WHEN hit.description IS NULL THEN NULL
+		ELSE ts_headline('simple', hit.description, q.query, 'StartSel=<mark>, StopSel=</mark>, MinWords=10, MaxWords=25, MaxFragments=2')
+	END
+	FROM (
+		SELECT ` + productColumns + `,
+		(ts_rank(p.search_vector, q.query) + word_similarity(@q, p.name))::FLOAT8 AS rank,
+		count(*) OVER()::INTEGER AS total
+		FROM ` + productFrom + `, to_tsquery('simple', @tsq) q(query)
+		WHERE ` + searchMatch + filter + `
+		ORDER BY rank DESC, p.id
+		LIMIT @limit OFFSET @offset
+	) hit, to_tsquery('simple', @tsq) q(query)
+	ORDER BY hit.rank DESC, hit.id::uuid`
+
+	// facets count every match, so picking a category doesn't hide the others
+	facetsQuery := `SELECT c.id::text, c.name, count(*)::INTEGER
+	FROM products p LEFT JOIN categories c ON c.id = p.category_id, to_tsquery('simple', @tsq) q(query)
+	WHERE ` + searchMatch + `
+	GROUP BY c.id, c.name
+	ORDER BY count(*) DESC, c.name`
+
+	ctx := context.Background()
+	hits := []searchHit{}
+	facets := []categoryFacet{}
+	rows, err := db.PG.Query(ctx, hitsQuery, args)
+	if err == nil {
+		hits, err = pgx.CollectRows(rows, pgx.RowToStructByPos[searchHit])
+	}
+	if err == nil {
+		rows, err = db.PG.Query(ctx, facetsQuery, args)
+	}
+	if err == nil {
+		facets, err = pgx.CollectRows(rows, pgx.RowToStructByPos[categoryFacet])
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		return responseSearch{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	total := 0
+	if len(hits) > 0 {
+		total = hits[0].Total
+	}
+	return responseSearch{
+		Status: "success",
+		Data:   hits,
+		Meta: &searchMeta{
+			pagination: pagination{Page: page, Limit: limit, Total: total},
+			Facets:     facets,
+		},
+		Errors: nil,
+	}
+}
@@ -0,0 +1,416 @@
+package seller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// MaxProductOptions is how many option axes, size or color say, a product may have.
+const MaxProductOptions = 3
+
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+var (
+	errVariantNotFound = errors.New("Variant not found")
+	errLastVariant     = errors.New("a product needs at least one variant, delete the product instead")
+	errVariantExists   = errors.New("a variant with these options already exists")
+	errSkuTaken        = errors.New("the sku is already used by another of your variants")
+	errStockPerVariant = errors.New("the product has several variants, set their stock one by one")
+	errVariantReserved = errors.New("pending checkouts hold stock of this variant")
+)
+
+// productOption is an axis a product varies on with the values it offers.
+type productOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// variant is what is actually sold. Price overrides the product price when
+// set, UnitPrice is the price that applies either way.
+type variant struct {
+	Id        string            `json:"id"`
+	Sku       *string           `json:"sku"`
+	Options   map[string]string `json:"options"`
+	Price     *int              `json:"price"`
+	UnitPrice int               `json:"unit_price"`
+	Stock     int               `json:"stock"`
+	Available int               `json:"available"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+type variantUpdate struct {
+	Sku   *string `json:"sku"`
+	Price *int    `json:"price"`
+	Stock *int    `json:"stock"`
+}
+
+func loadProductOptions(ctx context.Context, q querier, productId string) ([]productOption, error) {
+	rows, err := q.Query(ctx, `SELECT name, option_values FROM product_options WHERE product_id = $1 ORDER BY position`, productId)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[productOption])
+}
+
+// loadProductVariants returns the variants still for sale, oldest first.
+func loadProductVariants(ctx context.Context, q querier, productId string) ([]variant, error) {
+	rows, err := q.Query(ctx, `SELECT v.id::text, v.sku, v.options, v.price, COALESCE(v.price, p.price)::INTEGER, a.stock, a.available, v.created_at, v.updated_at
+	FROM product_variants v JOIN products p ON p.id = v.product_id
+	JOIN variant_availability a ON a.variant_id = v.id
+	WHERE v.product_id = $1 AND v.deleted_at IS NULL
+	ORDER BY v.created_at, v.id`, productId)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[variant])
+}
+
+func validateOptions(options []productOption) error {
+	if len(options) > MaxProductOptions {
+		return fmt.Errorf("a product can have at most %d options", MaxProductOptions)
+	}
+	names := map[string]bool{}
+	for _, option := range options {
+		if len(option.Name) == 0 || len(option.Name) > 30 || names[option.Name] {
+			return errors.New("option names must be unique and 1 to 30 characters long")
+		}
+		names[option.Name] = true
+		if len(option.Values) == 0 {
+			return errors.New("option " + option.Name + " needs at least one value")
+		}
+		values := map[string]bool{}
+		for _, value := range option.Values {
+			if len(value) == 0 || len(value) > 50 || values[value] {
+				return errors.New("values of option " + option.Name + " must be unique and 1 to 50 characters long")
+			}
+			values[value] = true
+		}
+	}
+	return nil
+}
+
+// validateVariant checks the sku, price and stock of a variant and that it
+// picks exactly one value of every option. With extend, a value the option
+// doesn't list yet is accepted, the caller adds it to the option.
+func validateVariant(options []productOption, v variant, extend bool) error {
+	if v.Sku != nil && !skuPattern.MatchString(*v.Sku) {
+		return errors.New("sku must be 1 to 64 letters, digits, dots, dashes or underscores")
+	}
+	if v.Price != nil && (*v.Price < 100 || *v.Price > 100000000) {
+		return errors.New("variant price must be between 100 and 100000000")
+	}
+	if v.Stock < 0 {
+		return errors.New("variant stock can't be negative")
+	}
+	if len(v.Options) != len(options) {
+		return errors.New("a variant must pick one value of every option")
+	}
+	for _, option := range options {
+		value, ok := v.Options[option.Name]
+		if !ok {
+			return errors.New("a variant must pick one value of every option")
+		}
+		known := false
+		for _, allowed := range option.Values {
+			known = known || allowed == value
+		}
+		if !known && (!extend || len(value) == 0 || len(value) > 50) {
+			return errors.New(value + " is not a value of option " + option.Name)
+		}
+	}
+	return nil
+}
+
+// prepareVariants validates the option matrix of a new product. Without
+// variants the product sells as a single variant holding the product stock.
+func prepareVariants(p *product) error {
+	if err := validateOptions(p.Options); err != nil {
+		return err
+	}
+	if len(p.Variants) == 0 {
+		if len(p.Options) > 0 {
+			return errors.New("a product with options needs its variants")
+		}
+		p.Variants = []variant{{Options: map[string]string{}, Stock: p.Stock}}
+	}
+
+	seen := map[string]bool{}
+	p.Stock = 0
+	for i := range p.Variants {
+		if p.Variants[i].Options == nil {
+			p.Variants[i].Options = map[string]string{}
+		}
+		if err := validateVariant(p.Options, p.Variants[i], false); err != nil {
+			return err
+		}
+		key := ""
+		for _, option := range p.Options {
+			key += p.Variants[i].Options[option.Name] + "\x00"
+		}
+		if seen[key] {
+			return errVariantExists
+		}
+		seen[key] = true
+		p.Stock += p.Variants[i].Stock
+	}
+	return nil
+}
+
+// insertVariants stores the options and variants of a new product, the stock
+// of each variant is booked as a restock.
+func insertVariants(ctx context.Context, tx pgx.Tx, p *product) error {
+	for i, option := range p.Options {
+		_, err := tx.Exec(ctx, `INSERT INTO product_options (product_id, name, position, option_values) VALUES ($1, $2, $3, $4)`,
+			p.Id, option.Name, i, option.Values)
+		if err != nil {
+			return err
+		}
+	}
+
+	var movements []StockMovement
+	for i := range p.Variants {
+		v := &p.Variants[i]
+		v.Id = uuid.New().String()
+		_, err := tx.Exec(ctx, `INSERT INTO product_variants (id, product_id, seller_id, sku, options, price, stock)
+		VALUES ($1, $2, $3, $4, $5, $6, 0)`, v.Id, p.Id, p.SellerId, v.Sku, v.Options, v.Price)
+		if err != nil {
+			return variantConflict(err)
+		}
+		if v.Stock > 0 {
+			movements = append(movements, StockMovement{
+				ProductId: p.Id,
+				VariantId: v.Id,
+				Reason:    MovementRestock,
+				Delta:     v.Stock,
+				ActorId:   &p.SellerId,
+			})
+		}
+	}
+	return ApplyStockMovements(ctx, tx, movements...)
+}
+
+// variantConflict maps the unique indexes of product_variants to their errors.
+func variantConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "product_variants_sku_key":
+			return errSkuTaken
+		case "product_variants_options_key":
+			return errVariantExists
+		}
+	}
+	return err
+}
+
+// variantError turns the errors of the variant handlers into a response.
+func variantError(err error) response {
+	code, message := 500, httperrors.C500
+	switch {
+	case err == errVariantNotFound:
+		code, message = 404, err.Error()
+	case err == errLastVariant, err == errVariantExists, err == errSkuTaken, err == errStockPerVariant,
+		err == errVariantReserved, err == errStockBelowReserved:
+		code, message = 409, err.Error()
+	default:
+		log.Println(err.Error())
+	}
+	return response{
+		Status: "failed",
+		Data:   nil,
+		Errors: &httperrors.Errors{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+func invalidVariation(err error) response {
+	return response{
+		Status: "failed",
+		Data:   nil,
+		Errors: &httperrors.Errors{
+			Code:    400,
+			Message: "Bad Request: " + err.Error(),
+		},
+	}
+}
+
+// lockOwnedProduct takes the product row lock variant writes serialise on and
+// reports whether the seller owns the product.
+func lockOwnedProduct(ctx context.Context, tx pgx.Tx, sellerId string, productId string) (bool, error) {
+	var id string
+	err := tx.QueryRow(ctx, `SELECT id::text FROM products WHERE id = $1 AND seller_id = $2 AND deleted_at IS NULL FOR UPDATE`,
+		productId, sellerId).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// addVariant adds a variant to a product. A value an option doesn't list yet
+// is added to the option, so a new size needs no separate call.
+func addVariant(sellerId string, productId string, v variant) response {
+	if v.Options == nil {
+		v.Options = map[string]string{}
+	}
+
+	ctx := context.Background()
+	var owns bool
+	var invalid error
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		var err error
+		if owns, err = lockOwnedProduct(ctx, tx, sellerId, productId); err != nil || !owns {
+			return err
+		}
+		options, err := loadProductOptions(ctx, tx, productId)
+		if err != nil {
+			return err
+		}
+		if invalid = validateVariant(options, v, true); invalid != nil {
+			return nil
+		}
+
+		for _, option := range options {
+			_, err = tx.Exec(ctx, `UPDATE product_options SET option_values = array_append(option_values, $3)
+			WHERE product_id = $1 AND name = $2 AND NOT ($3 = ANY(option_values))`, productId, option.Name, v.Options[option.Name])
+			if err != nil {
+				return err
+			}
+		}
+
+		v.Id = uuid.New().String()
+		_, err = tx.Exec(ctx, `INSERT INTO product_variants (id, product_id, seller_id, sku, options, price, stock)
+		VALUES ($1, $2, $3, $4, $5, $6, 0)`, v.Id, productId, sellerId, v.Sku, v.Options, v.Price)
+		if err != nil {
+			return variantConflict(err)
+		}
+		if v.Stock == 0 {
+			return nil
+		}
+		return ApplyStockMovements(ctx, tx, StockMovement{
+			ProductId: productId,
+			VariantId: v.Id,
+			Reason:    MovementRestock,
+			Delta:     v.Stock,
+			ActorId:   &sellerId,
+		})
+	})
+
+	switch {
+	case err != nil:
+		return variantError(err)
+	case !owns:
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: productNotFound(),
+		}
+	case invalid != nil:
+		return invalidVariation(invalid)
+	}
+	return getProductById(productId)
+}
+
+// updateVariant changes the sku, price and stock of a variant. The options of a
+// variant are what it is, a different combination is a new variant.
+func updateVariant(sellerId string, productId string, variantId string, req variantUpdate) response {
+	if err := validateVariant(nil, variant{Sku: req.Sku, Price: req.Price}, false); err != nil {
+		return invalidVariation(err)
+	}
+	if req.Stock != nil && *req.Stock < 0 {
+		return invalidVariation(errors.New("variant stock can't be negative"))
+	}
+
+	ctx := context.Background()
+	var owns bool
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		var err error
+		if owns, err = lockOwnedProduct(ctx, tx, sellerId, productId); err != nil || !owns {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `UPDATE product_variants SET sku = $3, price = $4, updated_at = now()
+		WHERE id = $1 AND product_id = $2 AND deleted_at IS NULL`, variantId, productId, req.Sku, req.Price)
+		if err != nil {
+			return variantConflict(err)
+		}
+		if tag.RowsAffected() == 0 {
+			return errVariantNotFound
+		}
+		if req.Stock == nil {
+			return nil
+		}
+		_, err = setStock(ctx, tx, productId, variantId, sellerId, *req.Stock)
+		return err
+	})
+
+	if err == nil && !owns {
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: productNotFound(),
+		}
+	}
+	if err != nil {
+		return variantError(err)
+	}
+	return getProductById(productId)
+}
+
+// deleteVariant takes a variant off sale. Its stock is written off first, which
+// fails while checkouts hold some of it. Orders keep pointing at the row.
+func deleteVariant(sellerId string, productId string, variantId string) response {
+	ctx := context.Background()
+	var owns bool
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		var err error
+		if owns, err = lockOwnedProduct(ctx, tx, sellerId, productId); err != nil || !owns {
+			return err
+		}
+
+		var exists bool
+		var live int
+		err = tx.QueryRow(ctx, `SELECT COALESCE(bool_or(id = $2), false), count(*) FROM product_variants
+		WHERE product_id = $1 AND deleted_at IS NULL`, productId, variantId).Scan(&exists, &live)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errVariantNotFound
+		}
+		if live == 1 {
+			return errLastVariant
+		}
+
+		if _, err = setStock(ctx, tx, productId, variantId, sellerId, 0); err == errStockBelowReserved {
+			return errVariantReserved
+		} else if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE product_variants SET deleted_at = now(), updated_at = now() WHERE id = $1`, variantId)
+		return err
+	})
+
+	if err == nil && !owns {
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: productNotFound(),
+		}
+	}
+	if err != nil {
+		return variantError(err)
+	}
+	return getProductById(productId)
+}
@@ -0,0 +1,77 @@
+package seller
+
+import "testing"
+
+func TestValidateVariant(t *testing.T) {
+	options := []productOption{
+		{Name: "size", Values: []string{"S", "M"}},
+		{Name: "color", Values: []string{"red"}},
+	}
+	sku := func(s string) *string { return &s }
+	price := func(p int) *int { return &p }
+
+	tests := []struct {
+		name    string
+		variant variant
+		extend  bool
+		wantErr string
+	}{
+		{
+			name:    "every option picked",
+			variant: variant{Sku: sku("MUG-S.red_1"), Price: price(100), Options: map[string]string{"size": "S", "color": "red"}},
+		},
+		{
+			name:    "bad sku",
+			variant: variant{Sku: sku("mug s"), Options: map[string]string{"size": "S", "color": "red"}},
+			wantErr: "sku must be 1 to 64 letters, digits, dots, dashes or underscores",
+		},
+		{
+			name:    "price too low",
+			variant: variant{Price: price(99), Options: map[string]string{"size": "S", "color": "red"}},
+			wantErr: "variant price must be between 100 and 100000000",
+		},
+		{
+			name:    "negative stock",
+			variant: variant{Stock: -1, Options: map[string]string{"size": "S", "color": "red"}},
+			wantErr: "variant stock can't be negative",
+		},
+		{
+			name:    "option missing",
+			variant: variant{Options: map[string]string{"size": "S"}},
+			wantErr: "a variant must pick one value of every option",
+		},
+		{
+			name:    "option unknown",
+			variant: variant{Options: map[string]string{"size": "S", "shape": "round"}},
+			wantErr: "a variant must pick one value of every option",
+		},
+		{
+			name:    "value unknown",
+			variant: variant{Options: map[string]string{"size": "XL", "color": "red"}},
+			wantErr: "XL is not a value of option size",
+		},
+		{
+			name:    "new value when extending",
+			variant: variant{Options: map[string]string{"size": "XL", "color": "red"}},
+			extend:  true,
+		},
+		{
+			name:    "empty value when extending",
+			variant: variant{Options: map[string]string{"size": "", "color": "red"}},
+			extend:  true,
+			wantErr: " is not a value of option size",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateVariant(options, tt.variant, tt.extend)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("error = %v, want none", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
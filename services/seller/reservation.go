@@ -0,0 +1,188 @@
+package seller
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	ReservationActive   = "active"
+	ReservationConsumed = "consumed"
+	ReservationReleased = "released"
+	ReservationExpired  = "expired"
+)
+
+// ReservationTTL is how long checkout holds stock for a payment,
+// RESERVATION_TTL takes a Go duration such as "15m".
+func ReservationTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("RESERVATION_TTL"))
+	if err != nil || ttl <= 0 {
+		return 15 * time.Minute
+	}
+	return ttl
+}
+
+// StockShortage is an order asking for more than is available.
+type StockShortage struct {
+	OrderId   string
+	ProductId string
+	VariantId string
+	Available int
+	Requested int
+}
+
+// lockProducts takes the row locks every stock change goes through, in id order
+// so concurrent checkouts can't deadlock.
+func lockProducts(ctx context.Context, tx pgx.Tx, orderIds []string) error {
+	_, err := tx.Exec(ctx, `SELECT id FROM products
+	WHERE id IN (SELECT product_id FROM orders WHERE id = ANY($1))
+	ORDER BY id FOR UPDATE`, orderIds)
+	return err
+}
+
+// ReserveStock holds stock for the orders of a checkout. When any order asks for
+// more than is available nothing is reserved and the shortages are returned.
+func ReserveStock(ctx context.Context, tx pgx.Tx, transactionId string, orderIds []string, ttl time.Duration) ([]StockShortage, error) {
+	if err := lockProducts(ctx, tx, orderIds); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT o.id::text, o.product_id::text, o.variant_id::text, a.available, o.quantity
+	FROM orders o JOIN variant_availability a ON a.variant_id = o.variant_id
+	WHERE o.id = ANY($1)`
+	rows, err := tx.Query(ctx, query, orderIds)
+	if err != nil {
+		return nil, err
+	}
+	requests, err := pgx.CollectRows(rows, pgx.RowToStructByPos[StockShortage])
+	if err != nil {
+		return nil, err
+	}
+
+	shortages := []StockShortage{}
+	for _, r := range requests {
+		if r.Requested > r.Available {
+			shortages = append(shortages, r)
+		}
+	}
+	if len(shortages) > 0 {
+		return shortages, nil
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"stock_reservations"},
+		[]string{"id", "product_id", "variant_id", "order_id", "transaction_id", "quantity", "expires_at"},
+		pgx.CopyFromSlice(len(requests), func(i int) ([]any, error) {
+			r := requests[i]
+			return []any{uuid.New(), r.ProductId, r.VariantId, r.OrderId, transactionId, r.Requested, expiresAt}, nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	movements := make([]StockMovement, 0, len(requests))
+	for i := range requests {
+		r := &requests[i]
+		movements = append(movements, StockMovement{
+			ProductId:   r.ProductId,
+			VariantId:   r.VariantId,
+			Reason:      MovementReservation,
+			Reserved:    r.Requested,
+			ReferenceId: &r.OrderId,
+		})
+	}
+	return nil, ApplyStockMovements(ctx, tx, movements...)
+}
+
+type reservedOrder struct {
+	OrderId   string
+	ProductId string
+	VariantId string
+	Quantity  int
+	Reserved  int
+}
+
+// activeReservations returns the orders with the quantity their active
+// reservation holds, 0 when there is none.
+func activeReservations(ctx context.Context, tx pgx.Tx, orderIds []string) ([]reservedOrder, error) {
+	query := `SELECT o.id::text, o.product_id::text, o.variant_id::text, o.quantity, COALESCE(r.quantity, 0)
+	FROM orders o LEFT JOIN stock_reservations r ON r.order_id = o.id AND r.status = 'active'
+	WHERE o.id = ANY($1)`
+	rows, err := tx.Query(ctx, query, orderIds)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[reservedOrder])
+}
+
+// ConsumeReservations turns the reservations of captured orders into a sale,
+// the real stock decrement.
+func ConsumeReservations(ctx context.Context, tx pgx.Tx, orderIds []string) error {
+	if err := lockProducts(ctx, tx, orderIds); err != nil {
+		return err
+	}
+
+	orders, err := activeReservations(ctx, tx, orderIds)
+	if err != nil {
+		return err
+	}
+
+	movements := make([]StockMovement, 0, len(orders))
+	for i := range orders {
+		o := &orders[i]
+		movements = append(movements, StockMovement{
+			ProductId:   o.ProductId,
+			VariantId:   o.VariantId,
+			Reason:      MovementSale,
+			Delta:       -o.Quantity,
+			Reserved:    -o.Reserved,
+			ReferenceId: &o.OrderId,
+		})
+	}
+	if err = ApplyStockMovements(ctx, tx, movements...); err != nil {
+		return err
+	}
+	return closeReservations(ctx, tx, orderIds, ReservationConsumed)
+}
+
+// ReleaseReservations gives the held stock of the orders back, status is
+// ReservationReleased or ReservationExpired.
+func ReleaseReservations(ctx context.Context, tx pgx.Tx, orderIds []string, status string) error {
+	orders, err := activeReservations(ctx, tx, orderIds)
+	if err != nil {
+		return err
+	}
+
+	movements := make([]StockMovement, 0, len(orders))
+	for i := range orders {
+		o := &orders[i]
+		if o.Reserved == 0 {
+			continue
+		}
+		movements = append(movements, StockMovement{
+			ProductId:   o.ProductId,
+			VariantId:   o.VariantId,
+			Reason:      MovementReservation,
+			Reserved:    -o.Reserved,
+			ReferenceId: &o.OrderId,
+			Note:        &status,
+		})
+	}
+	if err = ApplyStockMovements(ctx, tx, movements...); err != nil {
+		return err
+	}
+	return closeReservations(ctx, tx, orderIds, status)
+}
+
+func closeReservations(ctx context.Context, tx pgx.Tx, orderIds []string, status string) error {
+	_, err := tx.Exec(ctx, `UPDATE stock_reservations SET status = $1, closed_at = now()
+	WHERE order_id = ANY($2) AND status = 'active'`, status, orderIds)
+	return err
+}
@@ -0,0 +1,270 @@
+package seller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mime/multipart"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/media"
+	"github.com/dikletscode/isyana-store/services/review"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const shopPrefix = "shops/"
+
+var (
+	errSellerNotFound = errors.New("Seller not found")
+	errInvalidShop    = errors.New("Bad Request: shop name must be 3 to 100 characters, description at most 1000 and return policy at most 2000")
+	errShopNameTaken  = errors.New("Shop name already exists. Please use a different name.")
+)
+
+// shop is the public face of a seller. It is built from an explicit list of
+// columns, private ones such as password, shipping_address or the username
+// people log in with never get near it.
+type shop struct {
+	Id              string  `json:"id"`
+	DisplayName     string  `json:"display_name"`
+	Avatar          *string `json:"avatar"`
+	AvatarThumbnail *string `json:"avatar_thumbnail"`
+	ShopName        *string `json:"shop_name"`
+	Description     *string `json:"description"`
+	ReturnPolicy    *string `json:"return_policy"`
+	Banner          *string `json:"banner"`
+	BannerThumbnail *string `json:"banner_thumbnail"`
+	ProductCount    int     `json:"product_count"`
+	// JoinedAt is when the account was created, not when it started selling
+	JoinedAt time.Time         `json:"joined_at"`
+	Rating   review.Reputation `json:"rating" db:"-"`
+}
+
+type shopRequest struct {
+	Name         *string `json:"name"`
+	Description  *string `json:"description"`
+	ReturnPolicy *string `json:"return_policy"`
+}
+
+type responseShop struct {
+	Status string             `json:"status"`
+	Data   *shop              `json:"data"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+// publicMediaURL turns a stored avatar or banner key into the URL it is served
+// from. Avatars set before uploads existed hold a URL already and are returned
+// as they are.
+func publicMediaURL(key *string) *string {
+	if key == nil || (!strings.HasPrefix(*key, "avatars/") && !strings.HasPrefix(*key, shopPrefix)) {
+		return key
+	}
+	served := media.URL(*key)
+	return &served
+}
+
+func shopFailed(code int, message string) responseShop {
+	return responseShop{
+		Status: "failed",
+		Data:   nil,
+		Errors: &httperrors.Errors{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+// shopError maps the errors of the shop handlers to a response.
+func shopError(err error) responseShop {
+	var pgErr *pgconn.PgError
+	switch {
+	case err == errSellerNotFound:
+		return shopFailed(404, err.Error())
+	case err == errInvalidShop:
+		return shopFailed(400, err.Error())
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		return shopFailed(409, errShopNameTaken.Error())
+	}
+	log.Println(err.Error())
+	return shopFailed(500, httperrors.C500)
+}
+
+// trimSetting trims a setting and checks its length, blank settings are
+// cleared.
+func trimSetting(value *string, min int, max int) (*string, bool) {
+	if value == nil {
+		return nil, true
+	}
+	text := strings.TrimSpace(*value)
+	if text == "" {
+		return nil, true
+	}
+	length := utf8.RuneCountInString(text)
+	return &text, length >= min && length <= max
+}
+
+// isSeller reports whether id belongs to a seller account.
+func isSeller(ctx context.Context, id string) (bool, error) {
+	var seller bool
+	err := db.PG.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND user_type = 'S')`, id).Scan(&seller)
+	return seller, err
+}
+
+// getSellerProfile returns the storefront of a seller. Buyers and admins have
+// none, asking for theirs is the same as asking for an unknown id.
+func getSellerProfile(sellerId string) responseShop {
+	ctx := context.Background()
+	query := `SELECT u.id::text, COALESCE(s.name, NULLIF(TRIM(u.full_name), ''), 'Seller'), u.photo, u.photo_thumbnail,
+	s.name, s.description, s.return_policy, s.banner, s.banner_thumbnail,
+	(SELECT count(*)::INTEGER FROM products p WHERE p.seller_id = u.id AND p.deleted_at IS NULL),
+	u.created_at
+	FROM users u LEFT JOIN shops s ON s.seller_id = u.id
+	WHERE u.id = $1 AND u.user_type = 'S'`
+	rows, err := db.PG.Query(ctx, query, sellerId)
+	var profile shop
+	if err == nil {
+		profile, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[shop])
+	}
+	if err == pgx.ErrNoRows {
+		return shopError(errSellerNotFound)
+	}
+	if err == nil {
+		profile.Rating, err = review.SellerReputation(ctx, sellerId)
+	}
+	if err != nil {
+		return shopError(err)
+	}
+
+	profile.Avatar, profile.AvatarThumbnail = publicMediaURL(profile.Avatar), publicMediaURL(profile.AvatarThumbnail)
+	profile.Banner, profile.BannerThumbnail = publicMediaURL(profile.Banner), publicMediaURL(profile.BannerThumbnail)
+	return responseShop{
+		Status: "success",
+		Data:   &profile,
+		Errors: nil,
+	}
+}
+
+// getSellerProducts is GET /product narrowed to one seller, it takes the same
+// filters, sorts and cursor.
+func getSellerProducts(sellerId string, query url.Values) responseArr {
+	seller, err := isSeller(context.Background(), sellerId)
+	if err != nil {
+		log.Println(err.Error())
+		return responseArr{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+	if !seller {
+		return responseArr{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: &httperrors.Errors{
+				Code:    404,
+				Message: errSellerNotFound.Error(),
+			},
+		}
+	}
+
+	query.Set("seller_id", sellerId)
+	return getProducts(query)
+}
+
+// updateShop replaces the shop name, description and return policy of the
+// seller, the banner has its own endpoint.
+func updateShop(sellerId string, req shopRequest) responseShop {
+	var ok [3]bool
+	req.Name, ok[0] = trimSetting(req.Name, 3, 100)
+	req.Description, ok[1] = trimSetting(req.Description, 0, 1000)
+	req.ReturnPolicy, ok[2] = trimSetting(req.ReturnPolicy, 0, 2000)
+	if !ok[0] || !ok[1] || !ok[2] {
+		return shopError(errInvalidShop)
+	}
+
+	query := `INSERT INTO shops (seller_id, name, description, return_policy)
+	VALUES (@sellerId, @name, @description, @returnPolicy)
+	ON CONFLICT (seller_id) DO UPDATE SET name = EXCLUDED.name, description = EXCLUDED.description,
+	return_policy = EXCLUDED.return_policy, updated_at = now()`
+	args := pgx.NamedArgs{
+		"sellerId":     sellerId,
+		"name":         req.Name,
+		"description":  req.Description,
+		"returnPolicy": req.ReturnPolicy,
+	}
+	if _, err := db.PG.Exec(context.Background(), query, args); err != nil {
+		return shopError(err)
+	}
+	return getSellerProfile(sellerId)
+}
+
+// replaceBanner points the shop at new banner keys, nil for none, and removes
+// the files of the previous banner.
+func replaceBanner(ctx context.Context, sellerId string, key *string, thumbKey *string) error {
+	var oldKey, oldThumbKey *string
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `INSERT INTO shops (seller_id) VALUES ($1) ON CONFLICT (seller_id) DO NOTHING`, sellerId)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `UPDATE shops s SET banner = $2, banner_thumbnail = $3, updated_at = now()
+		FROM (SELECT seller_id, banner, banner_thumbnail FROM shops WHERE seller_id = $1 FOR UPDATE) old
+		WHERE s.seller_id = old.seller_id
+		RETURNING old.banner, old.banner_thumbnail`, sellerId, key, thumbKey).Scan(&oldKey, &oldThumbKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, old := range []*string{oldKey, oldThumbKey} {
+		if old == nil {
+			continue
+		}
+		if err := blobStore.Delete(ctx, *old); err != nil {
+			log.Println(err.Error())
+		}
+	}
+	return nil
+}
+
+func uploadShopBanner(sellerId string, file *multipart.FileHeader) responseShop {
+	img, err := media.ProcessFile(file)
+	if code := media.StatusCode(err); code != 0 {
+		return shopFailed(code, err.Error())
+	}
+
+	ctx := context.Background()
+	var key, thumbKey string
+	if err == nil {
+		key, thumbKey, err = media.Save(ctx, blobStore, shopPrefix+sellerId, uuid.New().String(), img)
+	}
+	if err == nil {
+		err = replaceBanner(ctx, sellerId, &key, &thumbKey)
+		if err != nil {
+			blobStore.Delete(ctx, key)
+			blobStore.Delete(ctx, thumbKey)
+		}
+	}
+	if err != nil {
+		return shopError(err)
+	}
+	return getSellerProfile(sellerId)
+}
+
+func deleteShopBanner(sellerId string) responseShop {
+	if err := replaceBanner(context.Background(), sellerId, nil, nil); err != nil {
+		return shopError(err)
+	}
+	return getSellerProfile(sellerId)
+}
@@ -0,0 +1,125 @@
+package seller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dikletscode/isyana-store/middleware"
+	"github.com/dikletscode/isyana-store/pkg/media"
+	"github.com/dikletscode/isyana-store/pkg/router"
+)
+
+// ShopRouter mounts the storefronts and the shop settings. Banners go to the
+// blob store SellerRouter was given.
+func ShopRouter(rt *router.Router) {
+	sellers := rt.Group("/seller")
+	sellerOnly := []router.Middleware{middleware.AuthMiddleware, middleware.RequireRole(middleware.RoleSeller)}
+
+	sellers.Get("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		resp := getSellerProfile(router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
+
+	sellers.Get("/{id:uuid}/products", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		resp := getSellerProducts(router.Param(r, "id"), r.URL.Query())
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
+
+	sellers.Put("/shop", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		var incoming shopRequest
+		var resp responseShop
+		err := json.NewDecoder(r.Body).Decode(&incoming)
+		if err != nil {
+			resp = shopFailed(400, "Bad Request: Invalid input data")
+		} else {
+			resp = updateShop(jwtUserID, incoming)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	sellers.Put("/shop/banner", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		var resp responseShop
+		files, err := media.Files(w, r, "banner", 1)
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
+		}
+		if code := media.StatusCode(err); code != 0 {
+			resp = shopFailed(code, err.Error())
+		} else if err != nil {
+			resp = shopFailed(400, "Bad Request: expected a multipart form with a banner")
+		} else {
+			resp = uploadShopBanner(jwtUserID, files[0])
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+	sellers.Delete("/shop/banner", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		resp := deleteShopBanner(jwtUserID)
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, sellerOnly...)
+
+}
@@ -0,0 +1,242 @@
+package seller
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/jackc/pgx/v5"
+)
+
+// PurgeRetention is how long soft-deleted rows are kept before the purge can
+// remove them, PURGE_RETENTION takes a Go duration such as "720h".
+func PurgeRetention() time.Duration {
+	retention, err := time.ParseDuration(os.Getenv("PURGE_RETENTION"))
+	if err != nil || retention <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return retention
+}
+
+type purgeResult struct {
+	Before   time.Time `json:"before"`
+	Products int64     `json:"products"`
+	Vouchers int64     `json:"vouchers"`
+}
+
+type responsePurge struct {
+	Status string             `json:"status"`
+	Data   *purgeResult       `json:"data"`
+	Errors *httperrors.Errors `json:"errors"`
+}
+
+func voucherNotFound() *httperrors.Errors {
+	return &httperrors.Errors{
+		Code:    404,
+		Message: "Voucher not found",
+	}
+}
+
+// deleteProduct takes a product off sale. Orders keep pointing at it, so the
+// row stays until the purge.
+func deleteProduct(sellerId string, productId string) response {
+	query := `UPDATE products SET deleted_at = now(), updated_at = now()
+	WHERE id = $1 AND seller_id = $2 AND deleted_at IS NULL`
+	comTag, err := db.PG.Exec(context.Background(), query, productId, sellerId)
+	if err != nil {
+		log.Println(err.Error())
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+	if comTag.RowsAffected() == 0 {
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: productNotFound(),
+		}
+	}
+
+	return response{
+		Status: "success",
+		Data:   nil,
+		Errors: nil,
+	}
+}
+
+func restoreProduct(sellerId string, productId string) response {
+	query := `UPDATE products SET deleted_at = NULL, updated_at = now()
+	WHERE id = $1 AND seller_id = $2 AND deleted_at IS NOT NULL`
+	comTag, err := db.PG.Exec(context.Background(), query, productId, sellerId)
+	if err != nil {
+		log.Println(err.Error())
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+	if comTag.RowsAffected() == 0 {
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: productNotFound(),
+		}
+	}
+
+	return getProductById(productId)
+}
+
+func deleteVoucher(voucherId string) responseVoucher {
+	query := `UPDATE vouchers SET deleted_at = now(), updated_at = now()
+	WHERE id = $1 AND deleted_at IS NULL`
+	comTag, err := db.PG.Exec(context.Background(), query, voucherId)
+	if err != nil {
+		log.Println(err.Error())
+		return responseVoucher{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+	if comTag.RowsAffected() == 0 {
+		return responseVoucher{
+			Status: "failed",
+			Data:   nil,
+			Errors: voucherNotFound(),
+		}
+	}
+
+	return responseVoucher{
+		Status: "success",
+		Data:   nil,
+		Errors: nil,
+	}
+}
+
+func restoreVoucher(voucherId string) responseVoucher {
+	query := `UPDATE vouchers v SET deleted_at = NULL, updated_at = now()
+	WHERE v.id = $1 AND v.deleted_at IS NOT NULL
+	RETURNING v.id::text, v.name, v.description, v.type, v.status, v.discount_percentage, v.created_at, v.updated_at,
+	COALESCE((SELECT array_agg(product_id::text) FROM voucher_products WHERE voucher_id = v.id), '{}')`
+
+	var voucher voucherType
+	err := db.PG.QueryRow(context.Background(), query, voucherId).Scan(&voucher.Id, &voucher.Name, &voucher.Description, &voucher.Type, &voucher.Status, &voucher.DiscountPercentage, &voucher.CreatedAt, &voucher.UpdatedAt, &voucher.ProductIds)
+	if err == pgx.ErrNoRows {
+		return responseVoucher{
+			Status: "failed",
+			Data:   nil,
+			Errors: voucherNotFound(),
+		}
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return responseVoucher{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	return responseVoucher{
+		Status: "success",
+		Data:   &voucher,
+		Errors: nil,
+	}
+}
+
+// purgeDeleted hard-deletes products and vouchers soft-deleted before the
+// retention window. Rows an order still points at are kept for the order
+// history, they are skipped rather than failing the purge.
+func purgeDeleted(ctx context.Context, retention time.Duration) (purgeResult, error) {
+	result := purgeResult{Before: time.Now().Add(-retention)}
+	var blobKeys []string
+
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		// the images cascade with their product, the query still sees them and
+		// hands back their files
+		err := tx.QueryRow(ctx, `WITH gone AS (
+			DELETE FROM products p
+			WHERE p.deleted_at < $1
+			AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.product_id = p.id)
+			RETURNING p.id
+		)
+		SELECT (SELECT count(*) FROM gone),
+		COALESCE((SELECT array_agg(k) FROM product_images i JOIN gone ON gone.id = i.product_id,
+			unnest(ARRAY[i.key, i.thumbnail_key]) k), '{}')`, result.Before).Scan(&result.Products, &blobKeys)
+		if err != nil {
+			return err
+		}
+
+		comTag, err := tx.Exec(ctx, `DELETE FROM vouchers v
+		WHERE v.deleted_at < $1
+		AND NOT EXISTS (SELECT 1 FROM order_transactions ot WHERE ot.voucher_id = v.id)`, result.Before)
+		if err != nil {
+			return err
+		}
+		result.Vouchers = comTag.RowsAffected()
+		return nil
+	})
+
+	if err == nil {
+		for _, key := range blobKeys {
+			if err := blobStore.Delete(ctx, key); err != nil {
+				log.Println(err.Error())
+			}
+		}
+	}
+	return result, err
+}
+
+func postPurge(retentionValue string) responsePurge {
+	retention := PurgeRetention()
+	if retentionValue != "" {
+		var err error
+		if retention, err = time.ParseDuration(retentionValue); err != nil || retention < 0 {
+			return responsePurge{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: retention must be a duration such as 720h",
+				},
+			}
+		}
+	}
+
+	result, err := purgeDeleted(context.Background(), retention)
+	if err != nil {
+		log.Println(err.Error())
+		return responsePurge{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	return responsePurge{
+		Status: "success",
+		Data:   &result,
+		Errors: nil,
+	}
+}
@@ -2,6 +2,7 @@ package seller
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/dikletscode/isyana-store/pkg/httperrors"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type voucherType struct {
@@ -18,6 +20,7 @@ type voucherType struct {
 	Type               string     `json:"type"` /** V0S = SINGLE  Product discount V0M = MULTIPLE Products discount V0C = COMBINE = Some Product discount **/
 	Status             string     `json:"status"`
 	DiscountPercentage float64    `json:"discount_percentage"`
+	ProductIds         []string   `json:"product_ids"`
 	CreatedAt          time.Time  `json:"created_at"`
 	UpdatedAt          time.Time  `json:"updated_at"`
 	DeletedAt          *time.Time `json:"-"`
@@ -42,8 +45,59 @@ func isValidType(typeVoucher string) bool {
 	return false
 }
 
+// isValidVoucher checks the product list against the voucher type: VOS targets
+// exactly one product, VOM any of several and VOC a combination of at least two.
+func isValidVoucher(voucher voucherType) bool {
+	if len(voucher.Name) <= 5 || voucher.Description == nil || len(*voucher.Description) >= 200 || !isValidType(voucher.Type) {
+		return false
+	}
+	if voucher.DiscountPercentage <= 0 || voucher.DiscountPercentage > 100 {
+		return false
+	}
+	if voucher.Status != "A" && voucher.Status != "I" {
+		return false
+	}
+
+	seen := map[string]bool{}
+	for _, productId := range voucher.ProductIds {
+		if _, err := uuid.Parse(productId); err != nil || seen[productId] {
+			return false
+		}
+		seen[productId] = true
+	}
+
+	switch voucher.Type {
+	case "VOS":
+		return len(voucher.ProductIds) == 1
+	case "VOM":
+		return len(voucher.ProductIds) >= 1
+	default:
+		return len(voucher.ProductIds) >= 2
+	}
+}
+
+func saveVoucherProducts(ctx context.Context, tx pgx.Tx, voucherId string, productIds []string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM voucher_products WHERE voucher_id = $1`, voucherId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"voucher_products"},
+		[]string{"voucher_id", "product_id"},
+		pgx.CopyFromSlice(len(productIds), func(i int) ([]any, error) {
+			return []any{voucherId, productIds[i]}, nil
+		}),
+	)
+	return err
+}
+
 func postVoucher(voucher voucherType) responseVoucher {
-	if len(voucher.Name) <= 5 || len(*voucher.Description) >= 200 || !isValidType(voucher.Type) {
+	if voucher.Status == "" {
+		voucher.Status = "A"
+	}
+	if !isValidVoucher(voucher) {
 		// log.Println(err.Error())
 		return responseVoucher{
 			Status: "failed",
@@ -67,10 +121,27 @@ func postVoucher(voucher voucherType) responseVoucher {
 		"status":             voucher.Status,
 		"discountPercentage": voucher.DiscountPercentage,
 	}
-	_, err := db.PG.Exec(context.Background(), query, args)
+	err := pgx.BeginFunc(context.Background(), db.PG, func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), query, args)
+		if err != nil {
+			return err
+		}
+		return saveVoucherProducts(context.Background(), tx, voucher.Id, voucher.ProductIds)
+	})
 
 	if err != nil {
 		log.Println(err.Error())
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return responseVoucher{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Unknown product id",
+				},
+			}
+		}
 
 		return responseVoucher{
 			Status: "failed",
@@ -89,7 +160,10 @@ func postVoucher(voucher voucherType) responseVoucher {
 }
 
 func putVoucher(voucher voucherType) responseVoucher {
-	if len(voucher.Name) <= 5 || len(*voucher.Description) >= 200 || !isValidType(voucher.Type) {
+	if voucher.Status == "" {
+		voucher.Status = "A"
+	}
+	if !isValidVoucher(voucher) {
 		// log.Println(err.Error())
 		return responseVoucher{
 			Status: "failed",
@@ -103,7 +177,7 @@ func putVoucher(voucher voucherType) responseVoucher {
 
 	query := `UPDATE vouchers SET 
 	name=@name, description=@description, type=@type, status=@status, 
-	discount_percentage=@discountPercentage where id=@id`
+	discount_percentage=@discountPercentage where id=@id AND deleted_at IS NULL`
 
 	args := pgx.NamedArgs{
 		"id":                 voucher.Id,
@@ -113,10 +187,42 @@ func putVoucher(voucher voucherType) responseVoucher {
 		"status":             voucher.Status,
 		"discountPercentage": voucher.DiscountPercentage,
 	}
-	_, err := db.PG.Exec(context.Background(), query, args)
+	var updated int64
+	err := pgx.BeginFunc(context.Background(), db.PG, func(tx pgx.Tx) error {
+		comTag, err := tx.Exec(context.Background(), query, args)
+		if err != nil {
+			return err
+		}
+		updated = comTag.RowsAffected()
+		if updated == 0 {
+			return nil
+		}
+		return saveVoucherProducts(context.Background(), tx, voucher.Id, voucher.ProductIds)
+	})
 
+	if err == nil && updated == 0 {
+		return responseVoucher{
+			Status: "failed",
+			Data:   nil,
+			Errors: &httperrors.Errors{
+				Code:    404,
+				Message: "Voucher not found",
+			},
+		}
+	}
 	if err != nil {
 		log.Println(err.Error())
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return responseVoucher{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Unknown product id",
+				},
+			}
+		}
 
 		return responseVoucher{
 			Status: "failed",
@@ -136,7 +242,11 @@ func putVoucher(voucher voucherType) responseVoucher {
 
 func getAllVoucher() responseVoucherArr {
 
-	query := `SELECT id,name, description,status,discount_percentage,created_at,updated_at FROM vouchers`
+	query := `SELECT v.id, v.name, v.description, v.type, v.status, v.discount_percentage, v.created_at, v.updated_at,
+	COALESCE(array_agg(vp.product_id::text) FILTER (WHERE vp.product_id IS NOT NULL), '{}')
+	FROM vouchers v LEFT JOIN voucher_products vp ON vp.voucher_id = v.id
+	WHERE v.deleted_at IS NULL
+	GROUP BY v.id`
 	rows, err := db.PG.Query(context.Background(), query)
 	var vouchers []voucherType
 	if err != nil {
@@ -154,7 +264,7 @@ func getAllVoucher() responseVoucherArr {
 
 	for rows.Next() {
 		var voucher voucherType
-		err = rows.Scan(&voucher.Id, &voucher.Name, &voucher.Description, &voucher.Status, &voucher.DiscountPercentage, &voucher.CreatedAt, &voucher.UpdatedAt)
+		err = rows.Scan(&voucher.Id, &voucher.Name, &voucher.Description, &voucher.Type, &voucher.Status, &voucher.DiscountPercentage, &voucher.CreatedAt, &voucher.UpdatedAt, &voucher.ProductIds)
 
 		if err != nil {
 			log.Println(err.Error())
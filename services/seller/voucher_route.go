@@ -3,113 +3,124 @@ package seller
 import (
 	"encoding/json"
 	"net/http"
-	"strings"
 
 	"github.com/dikletscode/isyana-store/middleware"
 	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/router"
 )
 
-func VocuherRoute() {
-	http.Handle("/voucher", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		if r.Method == http.MethodPost {
-
-			decoder := json.NewDecoder(r.Body)
-			var voucher voucherType
-			var resp responseVoucher
-			err := decoder.Decode(&voucher)
-			if err != nil {
-				// Handle the case where "jti" is not a string
-				resp = responseVoucher{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid input data",
-					},
-				}
+func VocuherRoute(rt *router.Router) {
+	vouchers := rt.Group("/voucher", middleware.AuthMiddleware)
+	adminOnly := middleware.RequireRole(middleware.RoleAdmin)
+
+	vouchers.Post("", func(w http.ResponseWriter, r *http.Request) {
+
+		decoder := json.NewDecoder(r.Body)
+		var voucher voucherType
+		var resp responseVoucher
+		err := decoder.Decode(&voucher)
+		if err != nil {
+			// Handle the case where "jti" is not a string
+			resp = responseVoucher{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
 			}
-			resp = postVoucher(voucher)
+		}
+		resp = postVoucher(voucher)
 
-			if resp.Status == "success" {
-				w.WriteHeader(http.StatusCreated)
-			} else {
-				w.WriteHeader(resp.Errors.Code)
-			}
-			err = json.NewEncoder(w).Encode(resp)
-			if err != nil {
-				http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
-			}
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
 
-		} else if r.Method == http.MethodGet {
+	}, adminOnly)
 
-			resp := getAllVoucher()
+	vouchers.Get("", func(w http.ResponseWriter, r *http.Request) {
 
-			if resp.Status == "success" {
-				w.WriteHeader(http.StatusOK)
-			} else {
-				w.WriteHeader(resp.Errors.Code)
-			}
-			err := json.NewEncoder(w).Encode(resp)
-			if err != nil {
-				http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
-			}
+		resp := getAllVoucher()
 
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
 		} else {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
 		}
 
-	}), nil))
-
-	http.Handle("/voucher/", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPut {
-			var resp responseVoucher
-			breakUrl := strings.Split(r.URL.Path, "/")
-			if len(breakUrl) <= 2 {
-				resp = responseVoucher{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid URL format",
-					},
-				}
+	})
+
+	vouchers.Put("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+		var resp responseVoucher
+
+		decoder := json.NewDecoder(r.Body)
+		var voucher voucherType
+
+		err := decoder.Decode(&voucher)
+		voucher.Id = router.Param(r, "id")
+		if err != nil {
+			// Handle the case where "jti" is not a string
+			resp = responseVoucher{
+				Status: "failed",
+				Data:   nil,
+				Errors: &httperrors.Errors{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
 			}
+		}
+		resp = putVoucher(voucher)
 
-			decoder := json.NewDecoder(r.Body)
-			var voucher voucherType
-			voucher.Id = breakUrl[len(breakUrl)-1]
-
-			err := decoder.Decode(&voucher)
-			if err != nil {
-				// Handle the case where "jti" is not a string
-				resp = responseVoucher{
-					Status: "failed",
-					Data:   nil,
-					Errors: &httperrors.Errors{
-						Code:    400,
-						Message: "Bad Request: Invalid input data",
-					},
-				}
-			}
-			resp = putVoucher(voucher)
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
 
-			if resp.Status == "success" {
-				w.WriteHeader(http.StatusOK)
-			} else {
-				w.WriteHeader(resp.Errors.Code)
-			}
-			err = json.NewEncoder(w).Encode(resp)
-			if err != nil {
-				http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
-			}
+	}, adminOnly)
+
+	vouchers.Delete("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+
+		resp := deleteVoucher(router.Param(r, "id"))
 
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
 		} else {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
+			w.WriteHeader(resp.Errors.Code)
 		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, adminOnly)
+
+	vouchers.Post("/{id:uuid}/restore", func(w http.ResponseWriter, r *http.Request) {
+
+		resp := restoreVoucher(router.Param(r, "id"))
 
-	}), nil))
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, adminOnly)
 
 }
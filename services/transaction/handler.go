@@ -2,12 +2,14 @@ package transaction
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"time"
 
 	"github.com/dikletscode/isyana-store/db"
 	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/payment"
+	"github.com/dikletscode/isyana-store/services/order"
+	"github.com/dikletscode/isyana-store/services/seller"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -20,12 +22,17 @@ type transaction struct {
 	FinalAmount      int       `json:"final_amount"`
 	Invoice          string    `json:"invoice"`
 	PaymentMethod    string    `json:"payment_method"`
+	PaymentProvider  string    `json:"payment_provider"`
+	PaymentIntentId  *string   `json:"payment_intent_id,omitempty"`
+	PaymentStatus    string    `json:"payment_status"`
+	VoucherId        *string   `json:"voucher_id,omitempty"`
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
 }
 
 type detailsErr struct {
 	OrderId           string `json:"order_id"`
+	VariantId         string `json:"variant_id"`
 	ProductStock      int    `json:"product_stock"`
 	RequestedQuantity int    `json:"requested_quantity"`
 }
@@ -44,7 +51,25 @@ type response struct {
 
 func addTransaction(newTransaction transaction, userId string, orderId []string) response {
 
-	if len(newTransaction.PaymentMethod) <= 1 || len(orderId) <= 0 {
+	if newTransaction.VoucherId != nil {
+		if _, err := uuid.Parse(*newTransaction.VoucherId); err != nil {
+			return response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &errCustom{
+					Code:    400,
+					Message: "Bad Request: voucher id is invalid",
+				},
+			}
+		}
+	}
+
+	if newTransaction.PaymentProvider == "" {
+		newTransaction.PaymentProvider = "mock"
+	}
+	provider, ok := payment.Get(newTransaction.PaymentProvider)
+
+	if !ok || len(newTransaction.PaymentMethod) <= 1 || len(orderId) <= 0 {
 		return response{
 			Status: "failed",
 			Data:   nil,
@@ -71,11 +96,12 @@ func addTransaction(newTransaction transaction, userId string, orderId []string)
 
 	defer tx.Rollback(ctx)
 
-	updateQuery := `UPDATE orders
-	SET purchase_status='COMPLETED'
-	WHERE purchase_status='IN_CART' AND id = ANY($1) `
+	lockQuery := `SELECT count(*) FROM (
+	SELECT id FROM orders WHERE id = ANY($1) AND user_id = $2 AND purchase_status = $3 FOR UPDATE
+	) AS locked`
 
-	cmdTag, err := tx.Exec(context.Background(), updateQuery, orderId)
+	var lockedCount int
+	err = tx.QueryRow(ctx, lockQuery, orderId, userId, order.StatusInCart).Scan(&lockedCount)
 	if err != nil {
 		log.Println(err.Error())
 		return response{
@@ -88,7 +114,7 @@ func addTransaction(newTransaction transaction, userId string, orderId []string)
 		}
 	}
 
-	if cmdTag.RowsAffected() == 0 {
+	if lockedCount == 0 || lockedCount != len(orderId) {
 		tx.Rollback(ctx)
 		return response{
 			Status: "failed",
@@ -100,32 +126,91 @@ func addTransaction(newTransaction transaction, userId string, orderId []string)
 		}
 	}
 
+	// the orders stay PENDING until the payment provider reports the outcome
+	err = order.Transition(ctx, tx, orderId, order.StatusPending, order.ActorSystem, userId)
+	if err != nil {
+		log.Println(err.Error())
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	lines, err := snapshotOrderLines(ctx, tx, userId, orderId)
+	if err == nil && len(lines) != len(orderId) {
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    400,
+				Message: "Bad Request: " + errProductUnavailable.Error(),
+			},
+		}
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	var v *voucher
+	if newTransaction.VoucherId != nil {
+		v, err = loadActiveVoucher(ctx, tx, *newTransaction.VoucherId)
+	}
+	var totals checkoutTotals
+	if err == nil {
+		totals, err = computeTotals(lines, v)
+	}
+	if err != nil {
+		if err == errVoucherNotFound || err == errVoucherNotApplicable {
+			return response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &errCustom{
+					Code:    400,
+					Message: "Bad Request: " + err.Error(),
+				},
+			}
+		}
+		log.Println(err.Error())
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
 	newTransaction.Id = uuid.New().String()
 	args := pgx.NamedArgs{
-
-		"discount":      0,
-		"invoice":       "https://www.invoicesimple.com/wp-content/uploads/2018/06/Sample-Invoice-printable.png",
-		"paymentMethod": newTransaction.PaymentMethod,
-		"userId":        userId,
 		"id":            newTransaction.Id,
+		"discount":      totals.Discount,
+		"subtotal":      totals.Subtotal,
+		"total":         totals.Total,
+		"invoice":       "/transaction/" + newTransaction.Id + "/invoice",
+		"paymentMethod": newTransaction.PaymentMethod,
+		"provider":      newTransaction.PaymentProvider,
+		"paymentStatus": payment.StatusPending,
 	}
 
-	query := `INSERT INTO transactions (id ,discount, pre_discount_amount, final_amount, invoice, payment_method)
-	SELECT @id, 
-	@discount, 
-	total,
-	total,
-	@invoice,
-	@paymentMethod
-	FROM (
-	SELECT SUM(orders.quantity*products.price) as total FROM orders JOIN products ON orders.product_id = products.id
- 	WHERE orders.user_id = @userId AND orders.purchase_status='COMPLETED'  
-	) as subquery
-	 
-	RETURNING *`
+	query := `INSERT INTO transactions (id, discount, pre_discount_amount, final_amount, invoice, payment_method, payment_provider, payment_status)
+	VALUES (@id, @discount, @subtotal, @total, @invoice, @paymentMethod, @provider, @paymentStatus)
+	RETURNING id, discount, pre_discount_amount, final_amount, invoice, payment_method, payment_status, created_at, updated_at`
 	rowsTransac := tx.QueryRow(context.Background(), query, args)
 
-	err = rowsTransac.Scan(&newTransaction.Id, &newTransaction.Discount, &newTransaction.PreDiscounAmount, &newTransaction.FinalAmount, &newTransaction.Invoice, &newTransaction.Invoice, &newTransaction.CreatedAt, &newTransaction.UpdatedAt)
+	err = rowsTransac.Scan(&newTransaction.Id, &newTransaction.Discount, &newTransaction.PreDiscounAmount, &newTransaction.FinalAmount, &newTransaction.Invoice, &newTransaction.PaymentMethod, &newTransaction.PaymentStatus, &newTransaction.CreatedAt, &newTransaction.UpdatedAt)
 	if err != nil {
 		log.Println(err.Error())
 		return response{
@@ -141,21 +226,22 @@ func addTransaction(newTransaction transaction, userId string, orderId []string)
 		Id            string
 		OrderId       string
 		TransactionId string
+		VoucherId     *string
 	}
 
 	lenOrder := len(orderId)
 	items := make([]item, 0, lenOrder)
 
 	for _, str := range orderId {
-		items = append(items, item{Id: uuid.New().String(), OrderId: str, TransactionId: newTransaction.Id})
+		items = append(items, item{Id: uuid.New().String(), OrderId: str, TransactionId: newTransaction.Id, VoucherId: newTransaction.VoucherId})
 	}
 
 	copyCount, err := tx.CopyFrom(
 		context.Background(),
 		pgx.Identifier{"order_transactions"},
-		[]string{"id", "orders_id", "transaction_id"},
+		[]string{"id", "orders_id", "transaction_id", "voucher_id"},
 		pgx.CopyFromSlice(len(items), func(i int) ([]any, error) {
-			return []any{items[i].Id, items[i].OrderId, items[i].TransactionId}, nil
+			return []any{items[i].Id, items[i].OrderId, items[i].TransactionId, items[i].VoucherId}, nil
 		}),
 	)
 
@@ -185,70 +271,30 @@ func addTransaction(newTransaction transaction, userId string, orderId []string)
 		}
 	}
 
-	batch := &pgx.Batch{}
-	for _, update := range orderId {
-		fmt.Println(update)
-		batch.Queue(`UPDATE products SET stock = stock - o.quantity FROM  orders o 
-					 WHERE o.id = $1 AND products.id = o.product_id 
-				     RETURNING o.id, o.quantity, stock`, update)
-	}
-
-	batchResult := tx.SendBatch(ctx, batch)
-
-	// rows, err := tx.Query(ctx, ``, orderId)
-
-	// defer rows.Close()
-
-	// arr := make([]detailsErr, batch.Len())
-
-	outOfStock := make([]detailsErr, 0, batch.Len())
-
-	for i := 0; i < batch.Len(); i++ {
-		rows, err := batchResult.Query()
-		if err != nil {
-			log.Println(err.Error())
-			return response{
-				Status: "failed",
-				Data:   nil,
-				Errors: &errCustom{
-					Code:    500,
-					Message: httperrors.C500,
-				},
-			}
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var r detailsErr
-
-			err := rows.Scan(&r.OrderId, &r.RequestedQuantity, &r.ProductStock)
-			if err != nil {
-				log.Println(err.Error())
-				return response{
-					Status: "failed",
-					Data:   nil,
-					Errors: &errCustom{
-						Code:    500,
-						Message: httperrors.C500,
-					},
-				}
-			}
-			fmt.Println(r.ProductStock, r.RequestedQuantity)
-			if r.ProductStock < 0 {
-
-				outOfStock = append(outOfStock, detailsErr{
-					OrderId:           r.OrderId,
-					ProductStock:      r.ProductStock + r.RequestedQuantity,
-					RequestedQuantity: r.RequestedQuantity,
-				})
-			}
-
+	// stock is held until the payment is captured or the reservation expires
+	shortages, err := seller.ReserveStock(ctx, tx, newTransaction.Id, orderId, seller.ReservationTTL())
+	if err != nil {
+		log.Println(err.Error())
+		return response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    500,
+				Message: httperrors.C500,
+			},
 		}
-
 	}
-	batchResult.Close()
 
-	if len(outOfStock) >= 1 {
+	if len(shortages) >= 1 {
+		outOfStock := make([]detailsErr, 0, len(shortages))
+		for _, shortage := range shortages {
+			outOfStock = append(outOfStock, detailsErr{
+				OrderId:           shortage.OrderId,
+				VariantId:         shortage.VariantId,
+				ProductStock:      shortage.Available,
+				RequestedQuantity: shortage.Requested,
+			})
+		}
 		tx.Rollback(ctx)
 		return response{
 			Status: "failed",
@@ -261,20 +307,6 @@ func addTransaction(newTransaction transaction, userId string, orderId []string)
 		}
 	}
 
-	// if cmmTag.RowsAffected() == 0 {
-
-	// 	log.Println("Orders quantities more than product stocks")
-	// 	tx.Rollback(ctx)
-	// 	return response{
-	// 		Status: "failed",
-	// 		Data:   nil,
-	// 		Errors: &errCustom{
-	// 			Code:    400,
-	// 			Message: "Insufficient stock for items: ",
-	// 		},
-	// 	}
-	// }
-
 	err = tx.Commit(ctx)
 	if err != nil {
 		log.Println("Error committing transaction:", err)
@@ -288,6 +320,26 @@ func addTransaction(newTransaction transaction, userId string, orderId []string)
 		}
 	}
 
+	// The checkout is committed from here on. When the provider can't be
+	// reached it is answered as created and pending, a 5xx would free the
+	// Idempotency-Key and a retry would check out the same orders again. The
+	// sweeper fails it once its reservations run out.
+	err = startPayment(ctx, provider, &newTransaction)
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	if newTransaction.PaymentStatus == payment.StatusFailed {
+		return response{
+			Status: "failed",
+			Data:   &newTransaction,
+			Errors: &errCustom{
+				Code:    402,
+				Message: "Payment failed, the orders were cancelled and their items put back in the cart",
+			},
+		}
+	}
+
 	return response{
 		Status: "success",
 		Data:   &newTransaction,
@@ -0,0 +1,302 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/payment"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+type pagination struct {
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+	Total int `json:"total"`
+}
+
+type responseArr struct {
+	Status string        `json:"status"`
+	Data   []transaction `json:"data"`
+	Meta   *pagination   `json:"meta"`
+	Errors *errCustom    `json:"errors"`
+}
+
+type transactionLine struct {
+	OrderId        string            `json:"order_id"`
+	ProductId      string            `json:"product_id"`
+	ProductName    string            `json:"product_name"`
+	VariantId      string            `json:"variant_id"`
+	Options        map[string]string `json:"options"`
+	Quantity       int               `json:"quantity"`
+	UnitPrice      int               `json:"unit_price"`
+	Amount         int               `json:"amount"`
+	PurchaseStatus string            `json:"purchase_status"`
+}
+
+type transactionVoucher struct {
+	Id                 string  `json:"id"`
+	Name               string  `json:"name"`
+	Description        *string `json:"description"`
+	Type               string  `json:"type"`
+	DiscountPercentage int     `json:"discount_percentage"`
+}
+
+type transactionDetail struct {
+	transaction
+	Voucher *transactionVoucher `json:"voucher"`
+	Lines   []transactionLine   `json:"lines"`
+}
+
+type responseDetail struct {
+	Status string             `json:"status"`
+	Data   *transactionDetail `json:"data"`
+	Errors *errCustom         `json:"errors"`
+}
+
+type transactionFilter struct {
+	Page          int
+	Limit         int
+	From          *time.Time
+	To            *time.Time
+	PaymentStatus string
+}
+
+// parseDate accepts a plain date or an RFC 3339 timestamp. A plain `to` date
+// covers the whole day.
+func parseDate(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+func parseTransactionFilter(query url.Values) (transactionFilter, error) {
+	filter := transactionFilter{Page: 1, Limit: defaultPageLimit, PaymentStatus: query.Get("payment_status")}
+	var err error
+
+	if page := query.Get("page"); page != "" {
+		filter.Page, err = strconv.Atoi(page)
+		if err != nil || filter.Page < 1 {
+			return filter, errors.New("page must be a positive number")
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxPageLimit {
+			return filter, errors.New("limit must be between 1 and 100")
+		}
+	}
+
+	if filter.From, err = parseDate(query.Get("from"), false); err != nil {
+		return filter, errors.New("from must be a date (YYYY-MM-DD) or an RFC 3339 timestamp")
+	}
+	if filter.To, err = parseDate(query.Get("to"), true); err != nil {
+		return filter, errors.New("to must be a date (YYYY-MM-DD) or an RFC 3339 timestamp")
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return filter, errors.New("from must be before to")
+	}
+
+	switch filter.PaymentStatus {
+	case "", payment.StatusPending, payment.StatusAuthorized, payment.StatusPaid, payment.StatusFailed, payment.StatusRefunded:
+	default:
+		return filter, errors.New("unknown payment_status")
+	}
+
+	return filter, nil
+}
+
+// a transaction belongs to the buyer whose orders it paid for
+const ownedByUser = `EXISTS (
+	SELECT 1 FROM order_transactions ot JOIN orders o ON o.id = ot.orders_id
+	WHERE ot.transaction_id = t.id AND o.user_id = @userId
+)`
+
+const transactionColumns = `t.id::text, t.discount, t.pre_discount_amount, t.final_amount, t.invoice, t.payment_method,
+	t.payment_provider, t.payment_intent_id, t.payment_status,
+	(SELECT ot.voucher_id::text FROM order_transactions ot WHERE ot.transaction_id = t.id AND ot.voucher_id IS NOT NULL LIMIT 1),
+	t.created_at, t.updated_at`
+
+func getMyTransactions(userId string, query url.Values) responseArr {
+	filter, err := parseTransactionFilter(query)
+	if err != nil {
+		return responseArr{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: &errCustom{
+				Code:    400,
+				Message: "Bad Request: " + err.Error(),
+			},
+		}
+	}
+
+	sql := `SELECT ` + transactionColumns + `, count(*) OVER ()
+	FROM transactions t
+	WHERE ` + ownedByUser + `
+	AND (@from::timestamptz IS NULL OR t.created_at >= @from)
+	AND (@to::timestamptz IS NULL OR t.created_at < @to)
+	AND (@paymentStatus = '' OR t.payment_status = @paymentStatus)
+	ORDER BY t.created_at DESC, t.id DESC
+	LIMIT @limit OFFSET @offset`
+	args := pgx.NamedArgs{
+		"userId":        userId,
+		"from":          filter.From,
+		"to":            filter.To,
+		"paymentStatus": filter.PaymentStatus,
+		"limit":         filter.Limit,
+		"offset":        (filter.Page - 1) * filter.Limit,
+	}
+
+	rows, err := db.PG.Query(context.Background(), sql, args)
+	if err != nil {
+		log.Println(err.Error())
+		return responseArr{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: &errCustom{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+	defer rows.Close()
+
+	transactions := make([]transaction, 0, filter.Limit)
+	total := 0
+	for rows.Next() {
+		var t transaction
+		err = rows.Scan(&t.Id, &t.Discount, &t.PreDiscounAmount, &t.FinalAmount, &t.Invoice, &t.PaymentMethod,
+			&t.PaymentProvider, &t.PaymentIntentId, &t.PaymentStatus, &t.VoucherId, &t.CreatedAt, &t.UpdatedAt, &total)
+		if err != nil {
+			break
+		}
+		transactions = append(transactions, t)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return responseArr{
+			Status: "failed",
+			Data:   nil,
+			Meta:   nil,
+			Errors: &errCustom{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	if len(transactions) == 0 && filter.Page > 1 {
+		// the window count is only known when the page has rows
+		err = db.PG.QueryRow(context.Background(), `SELECT count(*) FROM transactions t
+		WHERE `+ownedByUser+`
+		AND (@from::timestamptz IS NULL OR t.created_at >= @from)
+		AND (@to::timestamptz IS NULL OR t.created_at < @to)
+		AND (@paymentStatus = '' OR t.payment_status = @paymentStatus)`, args).Scan(&total)
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	return responseArr{
+		Status: "success",
+		Data:   transactions,
+		Meta:   &pagination{Page: filter.Page, Limit: filter.Limit, Total: total},
+		Errors: nil,
+	}
+}
+
+func getMyTransactionById(userId string, transactionId string) responseDetail {
+	ctx := context.Background()
+	var detail transactionDetail
+	t := &detail.transaction
+
+	sql := `SELECT ` + transactionColumns + ` FROM transactions t WHERE t.id = @id AND ` + ownedByUser
+	err := db.PG.QueryRow(ctx, sql, pgx.NamedArgs{"id": transactionId, "userId": userId}).Scan(&t.Id, &t.Discount,
+		&t.PreDiscounAmount, &t.FinalAmount, &t.Invoice, &t.PaymentMethod, &t.PaymentProvider, &t.PaymentIntentId,
+		&t.PaymentStatus, &t.VoucherId, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return responseDetail{
+				Status: "failed",
+				Data:   nil,
+				Errors: &errCustom{
+					Code:    404,
+					Message: "Transaction not found",
+				},
+			}
+		}
+		log.Println(err.Error())
+		return responseDetail{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	sql = `SELECT o.id::text, p.id::text, p.name, v.id::text, v.options, o.quantity,
+	COALESCE(o.unit_price, v.price, p.price::INTEGER), o.quantity * COALESCE(o.unit_price, v.price, p.price::INTEGER), o.purchase_status
+	FROM order_transactions ot
+	JOIN orders o ON o.id = ot.orders_id
+	JOIN product_variants v ON v.id = o.variant_id
+	JOIN products p ON p.id = o.product_id
+	WHERE ot.transaction_id = $1
+	ORDER BY o.created_at, o.id`
+	rows, err := db.PG.Query(ctx, sql, transactionId)
+	if err == nil {
+		detail.Lines, err = pgx.CollectRows(rows, pgx.RowToStructByPos[transactionLine])
+	}
+
+	if err == nil && t.VoucherId != nil {
+		var v transactionVoucher
+		sql = `SELECT id::text, name, description, type, COALESCE(discount_percentage, 0) FROM vouchers WHERE id = $1`
+		err = db.PG.QueryRow(ctx, sql, *t.VoucherId).Scan(&v.Id, &v.Name, &v.Description, &v.Type, &v.DiscountPercentage)
+		detail.Voucher = &v
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		return responseDetail{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	return responseDetail{
+		Status: "success",
+		Data:   &detail,
+		Errors: nil,
+	}
+}
@@ -0,0 +1,355 @@
+package transaction
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/pdf"
+	"github.com/dikletscode/isyana-store/pkg/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var errInvoiceNotFound = errors.New("invoice not found")
+
+// blobStore holds rendered invoices, it is set when the routes are mounted.
+var blobStore storage.BlobStore = storage.NewLocalStore("")
+
+type invoiceItem struct {
+	Description string
+	Quantity    int
+	UnitPrice   int
+	Amount      int
+}
+
+type invoice struct {
+	Id              string
+	TransactionId   string
+	Number          string
+	Year            int
+	Subtotal        int
+	Discount        int
+	TaxRate         float64
+	Tax             int
+	Total           int
+	BuyerName       *string
+	ShippingAddress *string
+	PaymentMethod   string
+	IssuedAt        time.Time
+	Items           []invoiceItem
+}
+
+// invoiceTaxRate is the VAT percentage included in prices, INVOICE_TAX_RATE.
+func invoiceTaxRate() float64 {
+	rate, err := strconv.ParseFloat(os.Getenv("INVOICE_TAX_RATE"), 64)
+	if err != nil || rate < 0 {
+		return 0
+	}
+	return rate
+}
+
+// issueInvoice numbers and snapshots the invoice of a paid transaction. It runs
+// in the same database transaction that marks the payment as paid, so the number
+// is only consumed when the payment is committed.
+func issueInvoice(ctx context.Context, tx pgx.Tx, transactionId string) error {
+	var exists bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM invoices WHERE transaction_id = $1)`, transactionId).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	var subtotal, discount, total int
+	query := `SELECT pre_discount_amount, discount, final_amount FROM transactions WHERE id = $1`
+	if err = tx.QueryRow(ctx, query, transactionId).Scan(&subtotal, &discount, &total); err != nil {
+		return err
+	}
+
+	year := time.Now().Year()
+	var sequence int
+	query = `INSERT INTO invoice_sequences (year, last_number) VALUES ($1, 1)
+	ON CONFLICT (year) DO UPDATE SET last_number = invoice_sequences.last_number + 1
+	RETURNING last_number`
+	if err = tx.QueryRow(ctx, query, year).Scan(&sequence); err != nil {
+		return err
+	}
+
+	rate := invoiceTaxRate()
+	invoiceId := uuid.New()
+	query = `INSERT INTO invoices
+	(id, transaction_id, invoice_number, year, sequence, subtotal, discount, tax_rate, tax, total, buyer_name, shipping_address)
+	SELECT @id, @transactionId, @number, @year, @sequence, @subtotal, @discount, @taxRate, @tax, @total,
+	COALESCE(u.full_name, u.username), u.shipping_address
+	FROM order_transactions ot
+	JOIN orders o ON o.id = ot.orders_id
+	JOIN users u ON u.id = o.user_id
+	WHERE ot.transaction_id = @transactionId
+	LIMIT 1`
+	args := pgx.NamedArgs{
+		"id":            invoiceId,
+		"transactionId": transactionId,
+		"number":        fmt.Sprintf("INV-%d-%06d", year, sequence),
+		"year":          year,
+		"sequence":      sequence,
+		"subtotal":      subtotal,
+		"discount":      discount,
+		"taxRate":       rate,
+		"tax":           int(math.Round(float64(total) * rate / (100 + rate))),
+		"total":         total,
+	}
+	if _, err = tx.Exec(ctx, query, args); err != nil {
+		return err
+	}
+
+	// a variant is described by its option values, "Shirt (M / Red)"
+	query = `INSERT INTO invoice_items (id, invoice_id, order_id, description, quantity, unit_price, amount)
+	SELECT gen_random_uuid(), $1, o.id,
+	left(p.name || COALESCE(' (' || (SELECT string_agg(v.options->>po.name, ' / ' ORDER BY po.position)
+		FROM product_options po WHERE po.product_id = p.id) || ')', ''), 100),
+	o.quantity, o.unit_price, o.quantity * o.unit_price
+	FROM order_transactions ot
+	JOIN orders o ON o.id = ot.orders_id
+	JOIN product_variants v ON v.id = o.variant_id
+	JOIN products p ON p.id = o.product_id
+	WHERE ot.transaction_id = $2`
+	_, err = tx.Exec(ctx, query, invoiceId, transactionId)
+	return err
+}
+
+// loadInvoice returns the invoice of a transaction the user bought.
+func loadInvoice(ctx context.Context, transactionId string, userId string) (*invoice, error) {
+	var inv invoice
+	query := `SELECT i.id::text, i.transaction_id::text, i.invoice_number, i.year, i.subtotal, i.discount,
+	i.tax_rate::float8, i.tax, i.total, i.buyer_name, i.shipping_address, t.payment_method, i.issued_at
+	FROM invoices i JOIN transactions t ON t.id = i.transaction_id
+	WHERE i.transaction_id = $1 AND EXISTS (
+		SELECT 1 FROM order_transactions ot JOIN orders o ON o.id = ot.orders_id
+		WHERE ot.transaction_id = i.transaction_id AND o.user_id = $2
+	)`
+	err := db.PG.QueryRow(ctx, query, transactionId, userId).Scan(&inv.Id, &inv.TransactionId, &inv.Number, &inv.Year,
+		&inv.Subtotal, &inv.Discount, &inv.TaxRate, &inv.Tax, &inv.Total, &inv.BuyerName, &inv.ShippingAddress,
+		&inv.PaymentMethod, &inv.IssuedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, errInvoiceNotFound
+		}
+		return nil, err
+	}
+
+	rows, err := db.PG.Query(ctx, `SELECT description, quantity, unit_price, amount FROM invoice_items
+	WHERE invoice_id = $1 ORDER BY description`, inv.Id)
+	if err != nil {
+		return nil, err
+	}
+	inv.Items, err = pgx.CollectRows(rows, pgx.RowToStructByPos[invoiceItem])
+	if err != nil {
+		return nil, err
+	}
+
+	return &inv, nil
+}
+
+func formatAmount(amount int) string {
+	s := strconv.Itoa(amount)
+	sign := ""
+	if amount < 0 {
+		sign, s = "-", s[1:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return sign + s
+}
+
+var invoiceTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"amount": formatAmount,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Invoice {{.Number}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; margin: 40px; color: #222; }
+table { width: 100%; border-collapse: collapse; margin-top: 24px; }
+th, td { padding: 6px 8px; border-bottom: 1px solid #ddd; text-align: left; }
+.num { text-align: right; }
+.totals td { border: none; }
+</style>
+</head>
+<body>
+<h1>Invoice {{.Number}}</h1>
+<p>Issued {{.IssuedAt.Format "02 Jan 2006"}}<br>Transaction {{.TransactionId}}<br>Payment method {{.PaymentMethod}}</p>
+<p><strong>Billed to</strong><br>{{with .BuyerName}}{{.}}{{end}}<br>{{with .ShippingAddress}}{{.}}{{end}}</p>
+<table>
+<tr><th>Item</th><th class="num">Qty</th><th class="num">Unit price</th><th class="num">Amount</th></tr>
+{{range .Items}}<tr><td>{{.Description}}</td><td class="num">{{.Quantity}}</td><td class="num">{{amount .UnitPrice}}</td><td class="num">{{amount .Amount}}</td></tr>
+{{end}}</table>
+<table class="totals">
+<tr><td class="num">Subtotal</td><td class="num">{{amount .Subtotal}}</td></tr>
+<tr><td class="num">Discount</td><td class="num">-{{amount .Discount}}</td></tr>
+<tr><td class="num">Total</td><td class="num"><strong>{{amount .Total}}</strong></td></tr>
+<tr><td class="num">Includes tax ({{.TaxRate}}%)</td><td class="num">{{amount .Tax}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+func renderInvoiceHTML(inv *invoice) ([]byte, error) {
+	var buf bytes.Buffer
+	err := invoiceTemplate.Execute(&buf, inv)
+	return buf.Bytes(), err
+}
+
+func renderInvoicePDF(inv *invoice) []byte {
+	doc := pdf.New()
+	const left, right = 50.0, 545.0
+	y := pdf.PageHeight - 60
+
+	doc.Text(left, y, 20, true, "Invoice "+inv.Number)
+	y -= 24
+	doc.Text(left, y, 10, false, "Issued "+inv.IssuedAt.Format("02 Jan 2006"))
+	y -= 14
+	doc.Text(left, y, 10, false, "Transaction "+inv.TransactionId)
+	y -= 14
+	doc.Text(left, y, 10, false, "Payment method "+inv.PaymentMethod)
+	y -= 28
+
+	doc.Text(left, y, 11, true, "Billed to")
+	if inv.BuyerName != nil {
+		y -= 14
+		doc.Text(left, y, 10, false, *inv.BuyerName)
+	}
+	if inv.ShippingAddress != nil {
+		y -= 14
+		doc.Text(left, y, 10, false, *inv.ShippingAddress)
+	}
+	y -= 30
+
+	header := func() {
+		doc.Text(left, y, 10, true, "Item")
+		doc.Text(330, y, 10, true, "Qty")
+		doc.Text(380, y, 10, true, "Unit price")
+		doc.Text(470, y, 10, true, "Amount")
+		y -= 6
+		doc.Line(left, right, y)
+		y -= 16
+	}
+	header()
+
+	for _, item := range inv.Items {
+		if y < 140 {
+			doc.NewPage()
+			y = pdf.PageHeight - 60
+			header()
+		}
+		doc.Text(left, y, 10, false, item.Description)
+		doc.Text(330, y, 10, false, strconv.Itoa(item.Quantity))
+		doc.Text(380, y, 10, false, formatAmount(item.UnitPrice))
+		doc.Text(470, y, 10, false, formatAmount(item.Amount))
+		y -= 16
+	}
+
+	doc.Line(left, right, y+8)
+	y -= 10
+	totals := [][2]string{
+		{"Subtotal", formatAmount(inv.Subtotal)},
+		{"Discount", "-" + formatAmount(inv.Discount)},
+		{"Total", formatAmount(inv.Total)},
+		{fmt.Sprintf("Includes tax (%g%%)", inv.TaxRate), formatAmount(inv.Tax)},
+	}
+	for _, row := range totals {
+		doc.Text(330, y, 10, row[0] == "Total", row[0])
+		doc.Text(470, y, 10, row[0] == "Total", row[1])
+		y -= 16
+	}
+
+	return doc.Bytes()
+}
+
+// invoiceDocument returns the rendered invoice, rendering and storing it on the
+// first download. Invoices are immutable so the stored copy never goes stale.
+func invoiceDocument(ctx context.Context, transactionId string, userId string, format string) ([]byte, *invoice, error) {
+	inv, err := loadInvoice(ctx, transactionId, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := fmt.Sprintf("invoices/%d/%s.%s", inv.Year, inv.Number, format)
+	stored, err := blobStore.Get(ctx, key)
+	if err == nil {
+		defer stored.Close()
+		content, err := io.ReadAll(stored)
+		return content, inv, err
+	}
+	if err != storage.ErrNotFound {
+		return nil, nil, err
+	}
+
+	var content []byte
+	if format == "html" {
+		content, err = renderInvoiceHTML(inv)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		content = renderInvoicePDF(inv)
+	}
+
+	if err = blobStore.Put(ctx, key, bytes.NewReader(content)); err != nil {
+		return nil, nil, err
+	}
+	return content, inv, nil
+}
+
+// downloadInvoice returns the invoice document of a transaction the user bought.
+// format is "pdf" or "html". resp is only set when the download failed.
+func downloadInvoice(userId string, transactionId string, format string) ([]byte, *invoice, *response) {
+	if format == "" {
+		format = "pdf"
+	}
+	if format != "pdf" && format != "html" {
+		return nil, nil, &response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    400,
+				Message: "format must be pdf or html",
+			},
+		}
+	}
+
+	content, inv, err := invoiceDocument(context.Background(), transactionId, userId, format)
+	if err != nil {
+		if err == errInvoiceNotFound {
+			return nil, nil, &response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &errCustom{
+					Code:    404,
+					Message: "Invoice not found, it is issued once the payment is captured",
+				},
+			}
+		}
+		log.Println(err.Error())
+		return nil, nil, &response{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	return content, inv, nil
+}
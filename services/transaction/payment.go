@@ -0,0 +1,360 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/payment"
+	"github.com/dikletscode/isyana-store/services/order"
+	"github.com/dikletscode/isyana-store/services/seller"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	errTransactionNotFound = errors.New("transaction not found")
+	errRefundNotRecorded   = errors.New("transaction has lines no refund was recorded for")
+)
+
+// paymentChain is the order a successful payment moves through. failed
+// branches off before paid. Statuses only ever move forward along the chain, so
+// the outcome doesn't depend on the order events arrive in: a refund reported
+// before its capture walks the transaction through paid to refunded, and the
+// late capture is then a no-op.
+var paymentChain = []string{payment.StatusPending, payment.StatusAuthorized, payment.StatusPaid, payment.StatusRefunded}
+
+func chainIndex(status string) int {
+	for i, s := range paymentChain {
+		if s == status {
+			return i
+		}
+	}
+	return -1
+}
+
+// paymentSteps returns the statuses to pass through to get from `from` to `to`,
+// or nothing when the move would go backwards or out of a final status.
+func paymentSteps(from string, to string) []string {
+	if to == payment.StatusFailed {
+		if from == payment.StatusPending || from == payment.StatusAuthorized {
+			return []string{payment.StatusFailed}
+		}
+		return nil
+	}
+
+	fromIdx, toIdx := chainIndex(from), chainIndex(to)
+	if fromIdx < 0 || toIdx <= fromIdx {
+		return nil
+	}
+	return paymentChain[fromIdx+1 : toIdx+1]
+}
+
+// startPayment opens an intent with the provider once the checkout is committed
+// and captures it straight away when the provider authorizes synchronously.
+func startPayment(ctx context.Context, provider payment.PaymentProvider, txn *transaction) error {
+	intent, err := provider.CreateIntent(ctx, payment.IntentRequest{
+		Reference: txn.Id,
+		Amount:    txn.FinalAmount,
+		Method:    txn.PaymentMethod,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.PG.Exec(ctx, `UPDATE transactions SET payment_intent_id = $1, updated_at = now() WHERE id = $2`, intent.Id, txn.Id)
+	if err != nil {
+		return err
+	}
+	txn.PaymentIntentId = &intent.Id
+
+	status, err := settleIntent(ctx, provider, txn.Id, intent)
+	if err != nil {
+		return err
+	}
+	txn.PaymentStatus = status
+	return nil
+}
+
+// settleIntent records the intent status and captures authorized intents.
+// It returns the payment_status the transaction ends up with.
+func settleIntent(ctx context.Context, provider payment.PaymentProvider, transactionId string, intent payment.Intent) (string, error) {
+	status, err := applyPaymentStatus(ctx, transactionId, intent.Status)
+	if err != nil || status != payment.StatusAuthorized {
+		return status, err
+	}
+
+	// a capture the provider turns down because the intent moved on, e.g. an
+	// earlier capture went through but its outcome was never recorded, still
+	// says where the intent is
+	captured, err := provider.Capture(ctx, intent.Id, intent.Amount)
+	if err != nil && (captured.Status == "" || captured.Status == payment.StatusAuthorized) {
+		return status, err
+	}
+	if err != nil {
+		log.Printf("capture of transaction %s: %s, the intent is %s", transactionId, err.Error(), captured.Status)
+	}
+	return applyPaymentStatus(ctx, transactionId, captured.Status)
+}
+
+// applyPaymentStatus moves the transaction and its orders to match the payment
+// outcome. Moves that would go backwards are ignored, so replaying an outcome is
+// harmless. It returns the resulting payment_status.
+func applyPaymentStatus(ctx context.Context, transactionId string, status string) (string, error) {
+	var current string
+
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		var err error
+		current, err = advancePayment(ctx, tx, transactionId, status)
+		return err
+	})
+
+	return current, err
+}
+
+func advancePayment(ctx context.Context, tx pgx.Tx, transactionId string, status string) (string, error) {
+	var current string
+	err := tx.QueryRow(ctx, `SELECT payment_status FROM transactions WHERE id = $1 FOR UPDATE`, transactionId).Scan(&current)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", errTransactionNotFound
+		}
+		return "", err
+	}
+
+	steps := paymentSteps(current, status)
+	if len(steps) == 0 {
+		return current, nil
+	}
+
+	rows, err := tx.Query(ctx, `SELECT orders_id::text FROM order_transactions WHERE transaction_id = $1`, transactionId)
+	if err != nil {
+		return "", err
+	}
+	orderIds, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return "", err
+	}
+
+	for _, step := range steps {
+		switch step {
+		case payment.StatusPaid:
+			err = seller.ConsumeReservations(ctx, tx, orderIds)
+			if err == nil {
+				err = order.Transition(ctx, tx, orderIds, order.StatusInProgress, order.ActorSystem, "")
+			}
+			if err == nil {
+				err = issueInvoice(ctx, tx, transactionId)
+			}
+		case payment.StatusRefunded:
+			// refundTransaction returns the lines and books their refunds and
+			// restock before it gets here, nothing else may close a transaction
+			var unrefunded bool
+			unrefunded, err = hasUnrefundedLines(ctx, tx, transactionId)
+			if err == nil && unrefunded {
+				err = errRefundNotRecorded
+			}
+		case payment.StatusFailed:
+			err = seller.ReleaseReservations(ctx, tx, orderIds, seller.ReservationReleased)
+			if err == nil {
+				err = order.ReturnToCart(ctx, tx, orderIds, "")
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE transactions SET payment_status = $1, updated_at = now() WHERE id = $2`, status, transactionId)
+	if err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+// hasUnrefundedLines reports whether the transaction still has order lines no
+// refund covers.
+func hasUnrefundedLines(ctx context.Context, tx pgx.Tx, transactionId string) (bool, error) {
+	var unrefunded bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS (
+		SELECT 1 FROM order_transactions ot
+		WHERE ot.transaction_id = $1
+		AND NOT EXISTS (SELECT 1 FROM refund_items ri WHERE ri.order_id = ot.orders_id)
+	)`, transactionId).Scan(&unrefunded)
+	return unrefunded, err
+}
+
+// processPaymentEvent stores the event and applies it exactly once. It reports
+// false when the event was already received before.
+func processPaymentEvent(ctx context.Context, provider payment.PaymentProvider, event payment.Event) (bool, error) {
+	var transactionId string
+	var amount int
+	var status string
+	var fresh bool
+
+	if len(event.Payload) == 0 {
+		event.Payload = []byte("{}")
+	}
+
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		var eventRowId string
+		query := `INSERT INTO payment_events (id, provider, event_id, event_type, intent_id, status, payload)
+		VALUES (@id, @provider, @eventId, @eventType, @intentId, @status, @payload)
+		ON CONFLICT (provider, event_id) DO NOTHING
+		RETURNING id::text`
+		args := pgx.NamedArgs{
+			"id":        uuid.New(),
+			"provider":  provider.Name(),
+			"eventId":   event.Id,
+			"eventType": event.Type,
+			"intentId":  event.IntentId,
+			"status":    event.Status,
+			"payload":   string(event.Payload),
+		}
+		err := tx.QueryRow(ctx, query, args).Scan(&eventRowId)
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fresh = true
+
+		query = `SELECT id::text, final_amount FROM transactions WHERE payment_provider = $1 AND payment_intent_id = $2`
+		err = tx.QueryRow(ctx, query, provider.Name(), event.IntentId).Scan(&transactionId, &amount)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return errTransactionNotFound
+			}
+			return err
+		}
+
+		// a refund made in the provider's dashboard has no refunds rows, amounts
+		// or restock behind it; the event is kept unprocessed for reconciliation
+		// instead of closing orders the ledger knows nothing about
+		if event.Status == payment.StatusRefunded {
+			unrefunded, err := hasUnrefundedLines(ctx, tx, transactionId)
+			if err != nil {
+				return err
+			}
+			if unrefunded {
+				log.Printf("payment event %s reports a refund of transaction %s that was not made through the API, reconcile it by hand", event.Id, transactionId)
+				return nil
+			}
+		}
+
+		status, err = advancePayment(ctx, tx, transactionId, event.Status)
+		if err != nil {
+			return err
+		}
+		// the checkout was given up on but the provider took the money, the
+		// event is kept unprocessed until someone refunds or restores it
+		if status == payment.StatusFailed && event.Status == payment.StatusPaid {
+			log.Printf("ALERT: payment event %s reports transaction %s as paid but it already failed and its orders were cancelled, refund the buyer or reconcile it by hand", event.Id, transactionId)
+			return nil
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE payment_events SET processed_at = now() WHERE id = $1`, eventRowId)
+		return err
+	})
+	if err != nil || !fresh {
+		return fresh, err
+	}
+
+	if status == payment.StatusAuthorized {
+		_, err = settleIntent(ctx, provider, transactionId, payment.Intent{Id: event.IntentId, Amount: amount, Status: status})
+	}
+	return true, err
+}
+
+// HandlePaymentEvent applies a notification delivered in-process, e.g. the mock
+// gateway settling a delayed payment. An error asks for the event to be
+// delivered again, the event is only recorded once it was applied.
+func HandlePaymentEvent(event payment.Event) error {
+	provider, ok := payment.Get(event.Provider)
+	if !ok {
+		log.Printf("payment event %s from unknown provider %s", event.Id, event.Provider)
+		return nil
+	}
+
+	_, err := processPaymentEvent(context.Background(), provider, event)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	return err
+}
+
+type webhookResult struct {
+	EventId   string `json:"event_id"`
+	Duplicate bool   `json:"duplicate"`
+}
+
+type webhookResponse struct {
+	Status string         `json:"status"`
+	Data   *webhookResult `json:"data"`
+	Errors *errCustom     `json:"errors"`
+}
+
+func receiveWebhook(providerName string, header http.Header, body []byte) webhookResponse {
+	provider, ok := payment.Get(providerName)
+	if !ok {
+		return webhookResponse{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    404,
+				Message: "Unknown payment provider",
+			},
+		}
+	}
+
+	event, err := provider.ParseWebhook(header, body)
+	if err != nil {
+		log.Println(err.Error())
+		code := 400
+		if err == payment.ErrBadSignature {
+			code = 401
+		}
+		return webhookResponse{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    code,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	fresh, err := processPaymentEvent(context.Background(), provider, event)
+	if err != nil {
+		if err == errTransactionNotFound {
+			// the intent id may not be saved yet, a non-2xx makes the provider retry
+			return webhookResponse{
+				Status: "failed",
+				Data:   nil,
+				Errors: &errCustom{
+					Code:    404,
+					Message: "Unknown payment intent",
+				},
+			}
+		}
+		log.Println(err.Error())
+		return webhookResponse{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    500,
+				Message: httperrors.C500,
+			},
+		}
+	}
+
+	return webhookResponse{
+		Status: "success",
+		Data:   &webhookResult{EventId: event.Id, Duplicate: !fresh},
+		Errors: nil,
+	}
+}
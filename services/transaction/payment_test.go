@@ -0,0 +1,37 @@
+package transaction
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dikletscode/isyana-store/pkg/payment"
+)
+
+func TestPaymentSteps(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want []string
+	}{
+		{payment.StatusPending, payment.StatusAuthorized, []string{payment.StatusAuthorized}},
+		{payment.StatusPending, payment.StatusPaid, []string{payment.StatusAuthorized, payment.StatusPaid}},
+		{payment.StatusAuthorized, payment.StatusRefunded, []string{payment.StatusPaid, payment.StatusRefunded}},
+		{payment.StatusPending, payment.StatusFailed, []string{payment.StatusFailed}},
+		{payment.StatusAuthorized, payment.StatusFailed, []string{payment.StatusFailed}},
+		// replays and late events never move backwards
+		{payment.StatusPaid, payment.StatusPaid, nil},
+		{payment.StatusPaid, payment.StatusAuthorized, nil},
+		{payment.StatusRefunded, payment.StatusPaid, nil},
+		{payment.StatusPaid, payment.StatusFailed, nil},
+		// failed is final
+		{payment.StatusFailed, payment.StatusPaid, nil},
+		{payment.StatusFailed, payment.StatusFailed, nil},
+		{payment.StatusPending, "unknown", nil},
+	}
+
+	for _, tt := range tests {
+		if got := paymentSteps(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("paymentSteps(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
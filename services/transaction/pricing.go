@@ -0,0 +1,52 @@
+package transaction
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type checkoutTotals struct {
+	Subtotal int
+	Discount int
+	Total    int
+}
+
+// snapshotOrderLines writes the current price of the variant onto the orders
+// being checked out and returns them as lines. Everything downstream, totals,
+// invoices, refunds, works from the snapshot so later price changes don't leak
+// in. Orders for deleted products or variants are left out, callers compare
+// the count.
+func snapshotOrderLines(ctx context.Context, tx pgx.Tx, userId string, orderIds []string) ([]orderLine, error) {
+	query := `UPDATE orders o SET unit_price = COALESCE(v.price, p.price)::INTEGER
+	FROM product_variants v JOIN products p ON p.id = v.product_id
+	WHERE v.id = o.variant_id AND o.id = ANY($1) AND o.user_id = $2
+	AND p.deleted_at IS NULL AND v.deleted_at IS NULL
+	RETURNING o.id::text, o.product_id::text, o.variant_id::text, o.quantity, o.unit_price`
+
+	rows, err := tx.Query(ctx, query, orderIds, userId)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[orderLine])
+}
+
+// computeTotals prices a checkout from its lines only, v may be nil.
+func computeTotals(lines []orderLine, v *voucher) (checkoutTotals, error) {
+	var totals checkoutTotals
+	for _, line := range lines {
+		totals.Subtotal += line.subtotal()
+	}
+
+	if v != nil {
+		discount, err := v.discountFor(lines)
+		if err != nil {
+			return totals, err
+		}
+		// the discount is stored and invoiced, it can't be more than was bought
+		totals.Discount = min(discount, totals.Subtotal)
+	}
+
+	totals.Total = totals.Subtotal - totals.Discount
+	return totals, nil
+}
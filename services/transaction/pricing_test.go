@@ -0,0 +1,84 @@
+package transaction
+
+import "testing"
+
+func TestComputeTotals(t *testing.T) {
+	// a buyer who already paid for product a checks out a second order of it;
+	// only the lines handed in count, at their snapshotted unit_price
+	lines := []orderLine{
+		{OrderId: "o2", ProductId: "a", VariantId: "a1", Quantity: 2, UnitPrice: 1500},
+		{OrderId: "o3", ProductId: "b", VariantId: "b1", Quantity: 1, UnitPrice: 4000},
+	}
+
+	tests := []struct {
+		name    string
+		lines   []orderLine
+		voucher *voucher
+		want    checkoutTotals
+		wantErr error
+	}{
+		{
+			name:  "no voucher",
+			lines: lines,
+			want:  checkoutTotals{Subtotal: 7000, Discount: 0, Total: 7000},
+		},
+		{
+			name:  "repeat purchase of a single line",
+			lines: lines[:1],
+			want:  checkoutTotals{Subtotal: 3000, Discount: 0, Total: 3000},
+		},
+		{
+			name:  "no lines",
+			lines: nil,
+			want:  checkoutTotals{},
+		},
+		{
+			name:    "single product voucher",
+			lines:   lines,
+			voucher: &voucher{Type: "VOS", DiscountPercentage: 10, ProductIds: map[string]bool{"a": true}},
+			want:    checkoutTotals{Subtotal: 7000, Discount: 300, Total: 6700},
+		},
+		{
+			name:    "multi product voucher",
+			lines:   lines,
+			voucher: &voucher{Type: "VOM", DiscountPercentage: 50, ProductIds: map[string]bool{"a": true, "b": true, "c": true}},
+			want:    checkoutTotals{Subtotal: 7000, Discount: 3500, Total: 3500},
+		},
+		{
+			name:    "combination voucher with every product",
+			lines:   lines,
+			voucher: &voucher{Type: "VOC", DiscountPercentage: 20, ProductIds: map[string]bool{"a": true, "b": true}},
+			want:    checkoutTotals{Subtotal: 7000, Discount: 1400, Total: 5600},
+		},
+		{
+			name:    "combination voucher missing a product",
+			lines:   lines[:1],
+			voucher: &voucher{Type: "VOC", DiscountPercentage: 20, ProductIds: map[string]bool{"a": true, "b": true}},
+			wantErr: errVoucherNotApplicable,
+		},
+		{
+			name:    "voucher for a product not being bought",
+			lines:   lines,
+			voucher: &voucher{Type: "VOS", DiscountPercentage: 10, ProductIds: map[string]bool{"c": true}},
+			wantErr: errVoucherNotApplicable,
+		},
+		{
+			name:    "discount is capped at the subtotal",
+			lines:   lines,
+			voucher: &voucher{Type: "VOM", DiscountPercentage: 150, ProductIds: map[string]bool{"a": true, "b": true}},
+			want:    checkoutTotals{Subtotal: 7000, Discount: 7000, Total: 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := computeTotals(tt.lines, tt.voucher)
+			if err != tt.wantErr {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && got != tt.want {
+				t.Errorf("totals = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
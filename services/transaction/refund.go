@@ -0,0 +1,300 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/httperrors"
+	"github.com/dikletscode/isyana-store/pkg/payment"
+	"github.com/dikletscode/isyana-store/services/order"
+	"github.com/dikletscode/isyana-store/services/seller"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	errNotRefundable    = errors.New("only paid transactions can be refunded")
+	errUnknownRefundRow = errors.New("order is not part of this transaction or was already refunded")
+)
+
+type refundRequest struct {
+	OrderIds []string `json:"order_ids"`
+	Restock  bool     `json:"restock"`
+	Reason   *string  `json:"reason"`
+}
+
+type refund struct {
+	Id               string    `json:"id"`
+	TransactionId    string    `json:"transaction_id"`
+	ProviderRefundId *string   `json:"provider_refund_id"`
+	Amount           int       `json:"amount"`
+	Reason           *string   `json:"reason"`
+	Restock          bool      `json:"restock"`
+	OrderIds         []string  `json:"order_ids"`
+	PaymentStatus    string    `json:"payment_status"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type responseRefund struct {
+	Status string     `json:"status"`
+	Data   *refund    `json:"data"`
+	Errors *errCustom `json:"errors"`
+}
+
+type refundLine struct {
+	OrderId   string
+	ProductId string
+	VariantId string
+	Quantity  int
+	Amount    int
+}
+
+// refundAmounts splits what was captured over the lines pro rata to their
+// subtotal, so a voucher discount is given back in proportion. When the refund
+// covers every line still open it returns exactly what is left, which absorbs
+// the rounding of earlier partial refunds.
+func refundAmounts(lines []orderLine, selected map[string]bool, captured int, subtotal int, refunded int, closesTransaction bool) ([]refundLine, int) {
+	result := make([]refundLine, 0, len(selected))
+	amount := 0
+	for _, line := range lines {
+		if !selected[line.OrderId] {
+			continue
+		}
+		share := 0
+		if subtotal > 0 {
+			share = line.subtotal() * captured / subtotal
+		}
+		result = append(result, refundLine{OrderId: line.OrderId, ProductId: line.ProductId, VariantId: line.VariantId, Quantity: line.Quantity, Amount: share})
+		amount += share
+	}
+
+	if closesTransaction && len(result) > 0 {
+		rest := captured - refunded - amount
+		result[len(result)-1].Amount += rest
+		amount += rest
+	}
+	return result, amount
+}
+
+// refundTransaction refunds the given order lines of a paid transaction, or all
+// of its open lines when orderIds is empty. The provider call happens inside the
+// database transaction: if the provider refuses, nothing is recorded, and if the
+// commit fails after the provider accepted, the error is logged with the provider
+// refund id for manual reconciliation.
+func refundTransaction(ctx context.Context, actorId string, transactionId string, req refundRequest) (*refund, error) {
+	var result *refund
+
+	err := pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		var providerName, status string
+		var intentId *string
+		var subtotal, captured, refunded int
+		query := `SELECT payment_provider, payment_intent_id, payment_status, pre_discount_amount, final_amount,
+		(SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE transaction_id = t.id)
+		FROM transactions t WHERE id = $1 FOR UPDATE`
+		err := tx.QueryRow(ctx, query, transactionId).Scan(&providerName, &intentId, &status, &subtotal, &captured, &refunded)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return errTransactionNotFound
+			}
+			return err
+		}
+		if status != payment.StatusPaid {
+			return errNotRefundable
+		}
+
+		query = `SELECT o.id::text, o.product_id::text, o.variant_id::text, o.quantity, COALESCE(o.unit_price, v.price, p.price::INTEGER),
+		EXISTS (SELECT 1 FROM refund_items ri WHERE ri.order_id = o.id)
+		FROM order_transactions ot
+		JOIN orders o ON o.id = ot.orders_id
+		JOIN product_variants v ON v.id = o.variant_id
+		JOIN products p ON p.id = o.product_id
+		WHERE ot.transaction_id = $1
+		ORDER BY o.id`
+		rows, err := tx.Query(ctx, query, transactionId)
+		if err != nil {
+			return err
+		}
+		var lines []orderLine
+		open := map[string]bool{}
+		for rows.Next() {
+			var line orderLine
+			var done bool
+			if err = rows.Scan(&line.OrderId, &line.ProductId, &line.VariantId, &line.Quantity, &line.UnitPrice, &done); err != nil {
+				rows.Close()
+				return err
+			}
+			lines = append(lines, line)
+			if !done {
+				open[line.OrderId] = true
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		selected := map[string]bool{}
+		if len(req.OrderIds) == 0 {
+			selected = open
+		}
+		for _, id := range req.OrderIds {
+			if !open[id] {
+				return errUnknownRefundRow
+			}
+			selected[id] = true
+		}
+		if len(selected) == 0 {
+			return errUnknownRefundRow
+		}
+
+		items, amount := refundAmounts(lines, selected, captured, subtotal, refunded, len(selected) == len(open))
+		if refunded+amount > captured {
+			return payment.ErrRefundTooLarge
+		}
+
+		orderIds := make([]string, 0, len(items))
+		for _, item := range items {
+			orderIds = append(orderIds, item.OrderId)
+		}
+		if err = order.Transition(ctx, tx, orderIds, order.StatusReturned, order.ActorSystem, actorId); err != nil {
+			return err
+		}
+
+		result = &refund{
+			Id:            uuid.New().String(),
+			TransactionId: transactionId,
+			Amount:        amount,
+			Reason:        req.Reason,
+			Restock:       req.Restock,
+			OrderIds:      orderIds,
+			PaymentStatus: status,
+		}
+
+		var createdBy *string
+		if actorId != "" {
+			createdBy = &actorId
+		}
+
+		if req.Restock {
+			movements := make([]seller.StockMovement, 0, len(items))
+			for _, item := range items {
+				movements = append(movements, seller.StockMovement{
+					ProductId:   item.ProductId,
+					VariantId:   item.VariantId,
+					Reason:      seller.MovementRefund,
+					Delta:       item.Quantity,
+					ReferenceId: &result.Id,
+					ActorId:     createdBy,
+				})
+			}
+			if err = seller.ApplyStockMovements(ctx, tx, movements...); err != nil {
+				return err
+			}
+		}
+
+		// the provider is called as late as possible, few steps can fail once money moved
+		if amount > 0 {
+			provider, ok := payment.Get(providerName)
+			if !ok || intentId == nil {
+				return payment.ErrUnknownIntent
+			}
+			providerRefund, err := provider.Refund(ctx, *intentId, amount)
+			if err != nil {
+				return err
+			}
+			result.ProviderRefundId = &providerRefund.Id
+		}
+
+		query = `INSERT INTO refunds (id, transaction_id, provider_refund_id, amount, reason, restock, created_by)
+		VALUES (@id, @transactionId, @providerRefundId, @amount, @reason, @restock, @createdBy)
+		RETURNING created_at`
+		args := pgx.NamedArgs{
+			"id":               result.Id,
+			"transactionId":    transactionId,
+			"providerRefundId": result.ProviderRefundId,
+			"amount":           amount,
+			"reason":           req.Reason,
+			"restock":          req.Restock,
+			"createdBy":        createdBy,
+		}
+		if err = tx.QueryRow(ctx, query, args).Scan(&result.CreatedAt); err != nil {
+			return err
+		}
+
+		_, err = tx.CopyFrom(
+			ctx,
+			pgx.Identifier{"refund_items"},
+			[]string{"refund_id", "order_id", "quantity", "amount"},
+			pgx.CopyFromSlice(len(items), func(i int) ([]any, error) {
+				return []any{result.Id, items[i].OrderId, items[i].Quantity, items[i].Amount}, nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+
+		if len(selected) == len(open) {
+			result.PaymentStatus, err = advancePayment(ctx, tx, transactionId, payment.StatusRefunded)
+		}
+		return err
+	})
+
+	if err != nil && result != nil && result.ProviderRefundId != nil {
+		log.Printf("refund %s accepted by the provider but not recorded for transaction %s", *result.ProviderRefundId, transactionId)
+	}
+	return result, err
+}
+
+func postRefund(actorId string, transactionId string, req refundRequest) responseRefund {
+	for _, id := range req.OrderIds {
+		if _, err := uuid.Parse(id); err != nil {
+			return responseRefund{
+				Status: "failed",
+				Data:   nil,
+				Errors: &errCustom{
+					Code:    400,
+					Message: "Bad Request: order id is invalid",
+				},
+			}
+		}
+	}
+
+	result, err := refundTransaction(context.Background(), actorId, transactionId, req)
+	if err != nil {
+		var transitionErr *order.TransitionError
+		code, message := 500, httperrors.C500
+		switch {
+		case err == errTransactionNotFound:
+			code, message = 404, "Transaction not found"
+		case err == errNotRefundable:
+			code, message = 409, err.Error()
+		case err == errUnknownRefundRow:
+			code, message = 400, "Bad Request: "+err.Error()
+		case errors.As(err, &transitionErr):
+			code, message = 409, err.Error()
+		case err == payment.ErrRefundTooLarge:
+			code, message = 422, err.Error()
+		case err == payment.ErrUnknownIntent || err == payment.ErrInvalidState:
+			code, message = 502, "Payment provider refused the refund: "+err.Error()
+		default:
+			log.Println(err.Error())
+		}
+		return responseRefund{
+			Status: "failed",
+			Data:   nil,
+			Errors: &errCustom{
+				Code:    code,
+				Message: message,
+			},
+		}
+	}
+
+	return responseRefund{
+		Status: "success",
+		Data:   result,
+		Errors: nil,
+	}
+}
@@ -2,186 +2,194 @@ package transaction
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/dikletscode/isyana-store/middleware"
+	"github.com/dikletscode/isyana-store/pkg/router"
+	"github.com/dikletscode/isyana-store/pkg/storage"
 )
 
 type transactionReq struct {
-	PaymentMethod string   `json:"payment_method"`
-	OrderId       []string `json:"order_id"`
+	PaymentMethod   string   `json:"payment_method"`
+	PaymentProvider string   `json:"payment_provider"`
+	OrderId         []string `json:"order_id"`
+	VoucherId       *string  `json:"voucher_id"`
 }
 
-func SellerRouter() {
-	http.Handle("/transaction", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func SellerRouter(rt *router.Router, store storage.BlobStore) {
+	blobStore = store
+	transactions := rt.Group("/transaction", middleware.AuthMiddleware)
+
+	transactions.Post("", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, ok := claims["jti"].(string)
+
+		decoder := json.NewDecoder(r.Body)
+		var transactionRequest transactionReq
+		var transaction transaction
+		var resp response
+
+		err := decoder.Decode(&transactionRequest)
+		transaction.PaymentMethod = transactionRequest.PaymentMethod
+		transaction.PaymentProvider = transactionRequest.PaymentProvider
+		transaction.VoucherId = transactionRequest.VoucherId
+		if !ok || err != nil {
+			// Handle the case where "jti" is not a string
+			resp = response{
+				Status: "failed",
+				Data:   nil,
+				Errors: &errCustom{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
+			}
+		}
+
+		resp = addTransaction(transaction, jwtUserID, transactionRequest.OrderId)
 
-		if r.Method == http.MethodPost {
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
 
-			claims := middleware.UserFromContext(r.Context())
+	}, middleware.Idempotency)
 
-			jwtUserID, ok := claims["jti"].(string)
+	transactions.Get("", func(w http.ResponseWriter, r *http.Request) {
 
-			decoder := json.NewDecoder(r.Body)
-			var transactionRequest transactionReq
-			var transaction transaction
-			var resp response
+		claims := middleware.UserFromContext(r.Context())
 
-			err := decoder.Decode(&transactionRequest)
-			transaction.PaymentMethod = transactionRequest.PaymentMethod
-			if !ok || err != nil {
-				// Handle the case where "jti" is not a string
-				resp = response{
-					Status: "failed",
-					Data:   nil,
-					Errors: &errCustom{
-						Code:    400,
-						Message: "Bad Request: Invalid input data",
-					},
-				}
-			}
+		jwtUserID, _ := claims["jti"].(string)
 
-			resp = addTransaction(transaction, jwtUserID, transactionRequest.OrderId)
+		resp := getMyTransactions(jwtUserID, r.URL.Query())
 
-			if resp.Status == "success" {
-				w.WriteHeader(http.StatusCreated)
-			} else {
-				w.WriteHeader(resp.Errors.Code)
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
+
+	transactions.Get("/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		resp := getMyTransactionById(jwtUserID, router.Param(r, "id"))
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
+
+	transactions.Post("/{id:uuid}/refunds", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+
+		jwtUserID, _ := claims["jti"].(string)
+
+		var req refundRequest
+		var resp responseRefund
+		// an empty body refunds every open line
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && err != io.EOF {
+			resp = responseRefund{
+				Status: "failed",
+				Data:   nil,
+				Errors: &errCustom{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
 			}
-			err = json.NewEncoder(w).Encode(resp)
+		} else {
+			resp = postRefund(jwtUserID, router.Param(r, "id"), req)
+		}
+
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	}, middleware.RequireRole(middleware.RoleAdmin), middleware.Idempotency)
+
+	transactions.Get("/{id:uuid}/invoice", func(w http.ResponseWriter, r *http.Request) {
+
+		claims := middleware.UserFromContext(r.Context())
+		jwtUserID, _ := claims["jti"].(string)
+		format := r.URL.Query().Get("format")
+
+		content, inv, resp := downloadInvoice(jwtUserID, router.Param(r, "id"), format)
+		if resp != nil {
+			w.WriteHeader(resp.Errors.Code)
+			err := json.NewEncoder(w).Encode(resp)
 			if err != nil {
 				http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
 			}
+			return
+		}
 
-		} else if r.Method == http.MethodGet {
-
-			// var resp responseArr
-
-			// claims := middleware.UserFromContext(r.Context())
-
-			// jwtUserID, ok := claims["jti"].(string)
-			// if !ok {
-			// 	// Handle the case where "jti" is not a string
-			// 	resp = responseArr{
-			// 		Status: "failed",
-			// 		Data:   nil,
-			// 		Errors: &httperrors.Errors{
-			// 			Code:    400,
-			// 			Message: "Bad Request: Invalid input data",
-			// 		},
-			// 	}
-			// }
-			// resp = getMyOrders(jwtUserID)
-
-			// if resp.Status == "success" {
-			// 	w.WriteHeader(http.StatusOK)
-			// } else {
-			// 	w.WriteHeader(resp.Errors.Code)
-			// }
-			// err := json.NewEncoder(w).Encode(resp)
-			// if err != nil {
-			// 	http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
-			// }
-
+		if format == "html" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		} else {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
+			format = "pdf"
+			w.Header().Set("Content-Type", "application/pdf")
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", inv.Number+"."+format))
+		w.WriteHeader(http.StatusOK)
+		w.Write(content)
+	})
+
+	// providers call this directly, the HMAC signature replaces the bearer token
+	rt.Post("/webhooks/payments/{provider}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		var resp webhookResponse
+		if err != nil {
+			resp = webhookResponse{
+				Status: "failed",
+				Data:   nil,
+				Errors: &errCustom{
+					Code:    400,
+					Message: "Bad Request: Invalid input data",
+				},
+			}
+		} else {
+			resp = receiveWebhook(router.Param(r, "provider"), r.Header, body)
 		}
 
-	}), nil))
-
-	// http.Handle("/order/", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-	// 	if r.Method == http.MethodPut {
-	// 		var resp response
-	// 		breakUrl := strings.Split(r.URL.Path, "/")
-	// 		if len(breakUrl) <= 2 {
-	// 			resp = response{
-	// 				Status: "failed",
-	// 				Data:   nil,
-	// 				Errors: &httperrors.Errors{
-	// 					Code:    400,
-	// 					Message: "Bad Request: Invalid URL format",
-	// 				},
-	// 			}
-	// 		}
-
-	// 		claims := middleware.UserFromContext(r.Context())
-
-	// 		jwtUserID, ok := claims["jti"].(string)
-
-	// 		decoder := json.NewDecoder(r.Body)
-	// 		var orderRequest order
-	// 		orderRequest.Id = breakUrl[len(breakUrl)-1]
-	// 		orderRequest.UserId = &jwtUserID
-
-	// 		err := decoder.Decode(&orderRequest)
-	// 		if !ok || err != nil {
-	// 			// Handle the case where "jti" is not a string
-	// 			resp = response{
-	// 				Status: "failed",
-	// 				Data:   nil,
-	// 				Errors: &httperrors.Errors{
-	// 					Code:    400,
-	// 					Message: "Bad Request: Invalid input data",
-	// 				},
-	// 			}
-	// 		}
-	// 		resp = updateOrder(orderRequest)
-
-	// 		if resp.Status == "success" {
-	// 			w.WriteHeader(http.StatusOK)
-	// 		} else {
-	// 			w.WriteHeader(resp.Errors.Code)
-	// 		}
-	// 		err = json.NewEncoder(w).Encode(resp)
-	// 		if err != nil {
-	// 			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
-	// 		}
-
-	// 	} else if r.Method == http.MethodGet {
-	// 		var resp response
-
-	// 		breakUrl := strings.Split(r.URL.Path, "/")
-	// 		if len(breakUrl) <= 2 {
-	// 			resp = response{
-	// 				Status: "failed",
-	// 				Data:   nil,
-	// 				Errors: &httperrors.Errors{
-	// 					Code:    400,
-	// 					Message: "Bad Request: Invalid URL format",
-	// 				},
-	// 			}
-	// 		}
-	// 		claims := middleware.UserFromContext(r.Context())
-
-	// 		jwtUserID, ok := claims["jti"].(string)
-	// 		if !ok {
-	// 			// Handle the case where "jti" is not a string
-	// 			resp = response{
-	// 				Status: "failed",
-	// 				Data:   nil,
-	// 				Errors: &httperrors.Errors{
-	// 					Code:    400,
-	// 					Message: "Bad Request: Invalid input data",
-	// 				},
-	// 			}
-	// 		}
-	// 		orderId := breakUrl[len(breakUrl)-1]
-	// 		resp = getMyOrderById(jwtUserID, orderId)
-
-	// 		if resp.Status == "success" {
-	// 			w.WriteHeader(http.StatusOK)
-	// 		} else {
-	// 			w.WriteHeader(resp.Errors.Code)
-	// 		}
-	// 		err := json.NewEncoder(w).Encode(resp)
-	// 		if err != nil {
-	// 			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
-	// 		}
-	// 	} else {
-	// 		w.WriteHeader(http.StatusMethodNotAllowed)
-	// 		return
-	// 	}
-
-	// })))
+		if resp.Status == "success" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(resp.Errors.Code)
+		}
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, "Oops! Something went wrong. We're working to fix the issue. Please try again later.", 500)
+		}
+	})
 
 }
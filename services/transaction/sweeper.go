@@ -0,0 +1,130 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/dikletscode/isyana-store/db"
+	"github.com/dikletscode/isyana-store/pkg/payment"
+	"github.com/dikletscode/isyana-store/services/seller"
+	"github.com/jackc/pgx/v5"
+)
+
+// SweepExpiredReservations runs until ctx is done. Every interval it fails the
+// checkouts whose payment is still pending after their reservations ran out,
+// which frees the stock and puts the orders back in the cart. Checkouts stuck in
+// authorized because their capture failed get one more capture attempt first,
+// see expireStuckCheckout.
+func SweepExpiredReservations(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := expireReservations(ctx); err != nil {
+			log.Println(err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func expireReservations(ctx context.Context) error {
+	query := `SELECT DISTINCT t.id::text, t.payment_status, t.payment_provider, t.payment_intent_id, t.final_amount
+	FROM stock_reservations r JOIN transactions t ON t.id = r.transaction_id
+	WHERE r.status = 'active' AND r.expires_at <= now() AND t.payment_status = ANY($1)
+	LIMIT 100`
+	rows, err := db.PG.Query(ctx, query, []string{payment.StatusPending, payment.StatusAuthorized})
+	if err != nil {
+		return err
+	}
+	checkouts, err := pgx.CollectRows(rows, pgx.RowToStructByPos[stuckCheckout])
+	if err != nil {
+		return err
+	}
+
+	for _, c := range checkouts {
+		if err = expireStuckCheckout(ctx, c); err != nil {
+			log.Printf("expiring checkout %s: %s", c.Id, err.Error())
+		}
+	}
+	return nil
+}
+
+type stuckCheckout struct {
+	Id              string
+	PaymentStatus   string
+	PaymentProvider string
+	PaymentIntentId *string
+	FinalAmount     int
+}
+
+// expireStuckCheckout gives up on a checkout whose reservations ran out. An
+// authorized one gets its capture retried first, the first capture failed and
+// nothing else retries it. Before the checkout is failed the intent is
+// cancelled at the provider so it can't be paid later; when the provider says
+// it was paid after all, that is recorded instead.
+func expireStuckCheckout(ctx context.Context, c stuckCheckout) error {
+	provider, ok := payment.Get(c.PaymentProvider)
+	if !ok || c.PaymentIntentId == nil {
+		return expireCheckout(ctx, c.Id, c.PaymentStatus)
+	}
+
+	if c.PaymentStatus == payment.StatusAuthorized {
+		intent := payment.Intent{Id: *c.PaymentIntentId, Amount: c.FinalAmount, Status: payment.StatusAuthorized}
+		status, err := settleIntent(ctx, provider, c.Id, intent)
+		if err == nil && status != payment.StatusAuthorized {
+			return nil
+		}
+		if err != nil {
+			log.Printf("capturing checkout %s: %s", c.Id, err.Error())
+		}
+	}
+
+	intent, err := provider.Cancel(ctx, *c.PaymentIntentId)
+	switch {
+	case errors.Is(err, payment.ErrUnknownIntent):
+		// nothing the provider could still charge
+	case err != nil && intent.Status != "" && intent.Status != c.PaymentStatus:
+		_, err = applyPaymentStatus(ctx, c.Id, intent.Status)
+		return err
+	case err != nil:
+		// try again on the next sweep rather than fail an intent that may still be paid
+		return err
+	}
+	return expireCheckout(ctx, c.Id, c.PaymentStatus)
+}
+
+// expireCheckout fails one checkout that is still in the expected status. The
+// transaction row lock makes it race safely with a capture arriving at the same
+// moment: whichever comes second sees the other's outcome. A pending checkout
+// that got authorized meanwhile is left alone, its capture is under way.
+func expireCheckout(ctx context.Context, transactionId string, expected string) error {
+	return pgx.BeginFunc(ctx, db.PG, func(tx pgx.Tx) error {
+		var status string
+		err := tx.QueryRow(ctx, `SELECT payment_status FROM transactions WHERE id = $1 FOR UPDATE`, transactionId).Scan(&status)
+		if err != nil || status != expected {
+			return err
+		}
+
+		rows, err := tx.Query(ctx, `SELECT orders_id::text FROM order_transactions WHERE transaction_id = $1`, transactionId)
+		if err != nil {
+			return err
+		}
+		orderIds, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return err
+		}
+
+		if err = seller.ReleaseReservations(ctx, tx, orderIds, seller.ReservationExpired); err != nil {
+			return err
+		}
+		_, err = advancePayment(ctx, tx, transactionId, payment.StatusFailed)
+		return err
+	})
+}
@@ -0,0 +1,89 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	errVoucherNotFound      = errors.New("voucher not found or inactive")
+	errVoucherNotApplicable = errors.New("voucher does not apply to these orders")
+	errProductUnavailable   = errors.New("some products are no longer for sale")
+)
+
+type voucher struct {
+	Id                 string
+	Type               string
+	DiscountPercentage int
+	ProductIds         map[string]bool
+}
+
+type orderLine struct {
+	OrderId   string
+	ProductId string
+	VariantId string
+	Quantity  int
+	UnitPrice int
+}
+
+func (l orderLine) subtotal() int {
+	return l.Quantity * l.UnitPrice
+}
+
+// loadActiveVoucher locks the voucher row so a concurrent deactivation can't
+// slip between the check and the commit of the checkout.
+func loadActiveVoucher(ctx context.Context, tx pgx.Tx, voucherId string) (*voucher, error) {
+	v := voucher{Id: voucherId, ProductIds: map[string]bool{}}
+
+	query := `SELECT type, COALESCE(discount_percentage, 0) FROM vouchers
+	WHERE id = $1 AND status = 'A' AND deleted_at IS NULL FOR SHARE`
+	err := tx.QueryRow(ctx, query, voucherId).Scan(&v.Type, &v.DiscountPercentage)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, errVoucherNotFound
+		}
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `SELECT product_id::text FROM voucher_products WHERE voucher_id = $1`, voucherId)
+	if err != nil {
+		return nil, err
+	}
+	productIds, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	for _, productId := range productIds {
+		v.ProductIds[productId] = true
+	}
+
+	return &v, nil
+}
+
+// discountFor applies the voucher to the purchased lines.
+//
+//	VOS: a single product, the discount covers every line of that product
+//	VOM: several products, each matching line is discounted on its own
+//	VOC: a combination, every listed product has to be in the purchase and the
+//	     discount covers their combined subtotal
+func (v *voucher) discountFor(lines []orderLine) (int, error) {
+	eligible := 0
+	matched := map[string]bool{}
+	for _, line := range lines {
+		if v.ProductIds[line.ProductId] {
+			eligible += line.subtotal()
+			matched[line.ProductId] = true
+		}
+	}
+
+	if len(matched) == 0 {
+		return 0, errVoucherNotApplicable
+	}
+	if v.Type == "VOC" && len(matched) != len(v.ProductIds) {
+		return 0, errVoucherNotApplicable
+	}
+
+	return eligible * v.DiscountPercentage / 100, nil
+}